@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A file found by expanding the files, directories and globs given to a command.
+type InputFile struct {
+	Path         string
+	RelativePath string // Where the file sits relative to the directory (or glob) it was found through
+}
+
+// Where the output for the file goes. Without an output directory, it goes next to the input.
+// With one, the tree structure of the input is mirrored inside it.
+func (input InputFile) OutputPath(outputDirectory string, extension string) string {
+	if outputDirectory == "" {
+		return withoutExtension(input.Path) + extension
+	}
+	return filepath.Join(outputDirectory, withoutExtension(input.RelativePath)+extension)
+}
+
+// Expands files, directories (recursively) and globs into the files with the given extension.
+// Also says whether this is a batch, i.e. anything other than a single file.
+func FindInputFiles(args []string, extension string) ([]InputFile, bool, error) {
+	hasExtension := func(path string) bool {
+		return strings.EqualFold(filepath.Ext(path), extension)
+	}
+
+	var inputs []InputFile
+	isBatch := len(args) > 1
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "*?[") {
+			isBatch = true
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, isBatch, UsageError{fmt.Sprintf("ERROR - Invalid glob '%s': %s", arg, err)}
+			}
+
+			// Files keep their path relative to the part of the glob before any wildcards
+			base := arg
+			for strings.ContainsAny(base, "*?[") {
+				base = filepath.Dir(base)
+			}
+
+			found := false
+			for _, match := range matches {
+				if fileInfo, err := os.Stat(match); err == nil && fileInfo.Mode().IsRegular() && hasExtension(match) {
+					relativePath, _ := filepath.Rel(base, match)
+					inputs = append(inputs, InputFile{Path: match, RelativePath: relativePath})
+					found = true
+				}
+			}
+			if !found {
+				return nil, isBatch, errors.New(fmt.Sprintf("ERROR - '%s' didn't match any %s files", arg, extension))
+			}
+			continue
+		}
+
+		fileInfo, err := os.Stat(arg)
+		if err != nil {
+			return nil, isBatch, errors.New(fmt.Sprintf("ERROR - %s", err))
+		}
+
+		if !fileInfo.IsDir() {
+			inputs = append(inputs, InputFile{Path: arg, RelativePath: filepath.Base(arg)})
+			continue
+		}
+
+		isBatch = true
+		found := false
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && hasExtension(path) {
+				relativePath, _ := filepath.Rel(arg, path)
+				inputs = append(inputs, InputFile{Path: path, RelativePath: relativePath})
+				found = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, isBatch, errors.New(fmt.Sprintf("ERROR - %s", err))
+		}
+		if !found {
+			return nil, isBatch, errors.New(fmt.Sprintf("ERROR - '%s' has no %s files in it", arg, extension))
+		}
+	}
+
+	return inputs, isBatch, nil
+}
+
+type BatchResult struct {
+	Input      InputFile
+	OutputPath string
+	Warnings   []string
+	UpToDate   bool // Nothing needed doing, e.g. the output was already built from the same input
+
+	IncludedFilePaths []string // Files the input included, so it can be compiled again when they change
+	Err        error
+}
+
+// Processes the files on several goroutines (one per CPU by default). Results are in the same order as the inputs.
+func RunBatch(inputs []InputFile, jobs int, process func(input InputFile) BatchResult) []BatchResult {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	results := make([]BatchResult, len(inputs))
+	indices := make(chan int)
+	var waitGroup sync.WaitGroup
+	for worker := 0; worker < jobs; worker++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for i := range indices {
+				results[i] = processSafely(inputs[i], process)
+			}
+		}()
+	}
+	for i := range inputs {
+		indices <- i
+	}
+	close(indices)
+	waitGroup.Wait()
+
+	return results
+}
+
+// A crash on one file shouldn't stop the rest of the batch.
+func processSafely(input InputFile, process func(input InputFile) BatchResult) (result BatchResult) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = BatchResult{Input: input, Err: InternalError{fmt.Sprintf("INTERNAL ERROR - %v", recovered)}}
+		}
+	}()
+	return process(input)
+}
+
+// Prints the failures and warnings, then a summary. Fails if any file did.
+func ReportBatch(results []BatchResult, verb string) error {
+	var failures []BatchResult
+	numberOfWarnings := 0
+	numberUpToDate := 0
+	for _, result := range results {
+		if result.UpToDate {
+			numberUpToDate++
+		}
+		if result.Err != nil {
+			failures = append(failures, result)
+			continue
+		}
+		for _, warning := range result.Warnings {
+			fmt.Printf("\n  WARNING - %s: %s", result.Input.Path, warning)
+			numberOfWarnings++
+		}
+	}
+	if numberOfWarnings > 0 {
+		fmt.Println()
+	}
+
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].Input.Path < failures[j].Input.Path
+	})
+	for _, failure := range failures {
+		fmt.Printf("\n  FAILED - %s\n    %s\n", failure.Input.Path, strings.Replace(failure.Err.Error(), "\n", "\n    ", -1))
+	}
+
+	if numberUpToDate > 0 {
+		fmt.Printf("\n  %s %d of %d file(s) (%d already up to date).\n", verb, len(results)-len(failures)-numberUpToDate, len(results), numberUpToDate)
+	} else {
+		fmt.Printf("\n  %s %d of %d file(s).\n", verb, len(results)-len(failures), len(results))
+	}
+	if len(failures) > 0 {
+		return errors.New(fmt.Sprintf("ERROR - %d file(s) failed", len(failures)))
+	}
+	return nil
+}
+
+// Writes a file, creating the directories it goes in (for mirroring the input tree).
+func writeOutputFile(path string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+	return nil
+}
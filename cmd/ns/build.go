@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byxor/NeverScript/compiler"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+var buildCommand = Command{
+	Name:        "build",
+	Arguments:   "[flags] [file.ns|directory|glob]... [-o <directory>]",
+	Description: "Compile NeverScript code (by default, the project's sources and pres) into an output directory, skipping files that haven't changed since the last build.",
+	Run:         RunBuild,
+}
+
+// The cache manifest is kept next to the output directory, e.g. 'build/scripts.nscache' for 'build/scripts'.
+const buildCacheExtension = ".nscache"
+
+type BuildCache struct {
+	Version string                     `json:"version"`
+	Outputs map[string]BuildCacheEntry `json:"outputs"` // Keyed by the output's path inside the output directory
+}
+
+type BuildCacheEntry struct {
+	Input      string `json:"input"`
+	InputHash  string `json:"inputHash"`  // Covers the source and everything else that affects the output
+	OutputHash string `json:"outputHash"` // Catches outputs that were changed or replaced after the build
+
+	IncludedFileHashes map[string]string `json:"includedFileHashes,omitempty"` // Files the input included when it was compiled
+}
+
+// Whether the files the input included are still the same.
+func (entry BuildCacheEntry) IncludedFilesAreUnchanged() bool {
+	for path, includedFileHash := range entry.IncludedFileHashes {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil || hashBytes(contents) != includedFileHash {
+			return false
+		}
+	}
+	return true
+}
+
+func BuildCachePath(outputDirectory string) string {
+	return filepath.Clean(outputDirectory) + buildCacheExtension
+}
+
+// A missing or unreadable cache isn't an error, it just means everything gets compiled.
+func ReadBuildCache(path string) BuildCache {
+	cache := BuildCache{Version: version, Outputs: map[string]BuildCacheEntry{}}
+	cacheBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	var existingCache BuildCache
+	if err := json.Unmarshal(cacheBytes, &existingCache); err != nil || existingCache.Outputs == nil {
+		return cache
+	}
+	if existingCache.Version != version {
+		// Another version of ns might compile the same source differently
+		return cache
+	}
+	cache.Outputs = existingCache.Outputs
+	return cache
+}
+
+func WriteBuildCache(path string, cache BuildCache) error {
+	cacheBytes, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+	return writeOutputFile(path, append(cacheBytes, '\n'))
+}
+
+// Hashes the source together with the compiler version and every setting that changes the bytecode.
+// The settings are hashed after the flags are applied, so e.g. -lineNumbers matching the target game's default
+// doesn't cause a rebuild.
+func (flags CompilationFlags) HashInput(source []byte) string {
+	var bytecodeCompiler compiler.BytecodeCompiler
+	flags.ApplyTo(&bytecodeCompiler)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "ns %s\x00targetGame=%s\x00removeChecksums=%t\x00lineNumbers=%t\x00preserveLineNumbers=%t\x00newcompiler=%t\x00",
+		version, bytecodeCompiler.TargetGame, bytecodeCompiler.RemoveChecksums, bytecodeCompiler.WriteLineNumbers, bytecodeCompiler.PreserveLineNumbers, *flags.NewCompiler)
+	hash.Write(source)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func hashBytes(contents []byte) string {
+	hash := sha256.Sum256(contents)
+	return hex.EncodeToString(hash[:])
+}
+
+func RunBuild(command Command, args []string) error {
+	project, err := FindProject()
+	if err != nil {
+		return err
+	}
+	flagSet := command.NewFlagSet()
+	outputDirectory := flagSet.String("o", "", "Specify the output directory. The tree structure of the input is mirrored inside it (defaults to the project's output).")
+	force := flagSet.Bool("force", false, "Compile every file, even the ones that haven't changed.")
+	compilationFlags := AddCompilationFlags(flagSet, project)
+	jobs := flagSet.Int("jobs", runtime.NumCPU(), "Specify how many files to compile at once.")
+	positionalArgs, err := command.ParseFlags(flagSet, args, 0, -1)
+	if err != nil {
+		return err
+	}
+	if err := compilationFlags.Validate(); err != nil {
+		return err
+	}
+
+	// Without arguments, the whole project is built, including its pres
+	isProjectBuild := len(positionalArgs) == 0
+	if isProjectBuild {
+		if project == nil || len(project.Sources) == 0 {
+			return UsageError{fmt.Sprintf("ERROR - Specify what to build, or list the sources in %s (see '%s -h')", projectFileName, command.FullName())}
+		}
+		positionalArgs = project.ResolvedSources()
+	}
+	if *outputDirectory == "" && project != nil {
+		*outputDirectory = project.Resolve(project.Output)
+	}
+	if *outputDirectory == "" {
+		return UsageError{fmt.Sprintf("ERROR - Specify the output directory with -o, e.g. '%s scripts -o build'", command.FullName())}
+	}
+
+	inputs, _, err := FindInputFiles(positionalArgs, ".ns")
+	if err != nil {
+		return err
+	}
+
+	// A project with several target games gets a directory for each, e.g. 'build/thug2'
+	targetGames := []string{*compilationFlags.TargetGame}
+	if project != nil && len(project.TargetGames) > 1 && !flagWasGiven(flagSet, "targetGame") {
+		targetGames = project.TargetGames
+	}
+
+	var buildErr error
+	for _, targetGame := range targetGames {
+		targetOutputDirectory := *outputDirectory
+		if len(targetGames) > 1 {
+			targetOutputDirectory = filepath.Join(*outputDirectory, targetGame)
+		}
+		fmt.Printf("\nBuilding %d file(s) into '%s'...\n", len(inputs), targetOutputDirectory)
+		err := BuildFiles(inputs, targetOutputDirectory, compilationFlags.WithTargetGame(targetGame), *force, *jobs)
+		if err != nil && buildErr == nil {
+			buildErr = err
+		}
+	}
+	if buildErr != nil {
+		return buildErr
+	}
+
+	if isProjectBuild && len(project.Pres) > 0 {
+		return BuildProjectPres(project, compilationFlags, flagWasGiven(flagSet, "targetGame"))
+	}
+	return nil
+}
+
+// Compiles the files that changed since the last build into the output directory, then reports what happened.
+func BuildFiles(inputs []InputFile, outputDirectory string, compilationFlags CompilationFlags, force bool, jobs int) error {
+	cachePath := BuildCachePath(outputDirectory)
+	oldCache := ReadBuildCache(cachePath)
+	newCache := BuildCache{Version: version, Outputs: map[string]BuildCacheEntry{}}
+	var newCacheLock sync.Mutex
+
+	results := RunBatch(inputs, jobs, func(input InputFile) BatchResult {
+		result := BatchResult{Input: input, OutputPath: input.OutputPath(outputDirectory, ".qb")}
+		cacheKey := filepath.ToSlash(withoutExtension(input.RelativePath) + ".qb")
+
+		source, err := readFile(input.Path)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		entry := BuildCacheEntry{Input: filepath.ToSlash(input.Path), InputHash: compilationFlags.HashInput(source)}
+
+		if oldEntry, found := oldCache.Outputs[cacheKey]; found && !force && oldEntry.InputHash == entry.InputHash && oldEntry.IncludedFilesAreUnchanged() {
+			if output, err := ioutil.ReadFile(result.OutputPath); err == nil && hashBytes(output) == oldEntry.OutputHash {
+				result.UpToDate = true
+				newCacheLock.Lock()
+				newCache.Outputs[cacheKey] = oldEntry
+				newCacheLock.Unlock()
+				return result
+			}
+		}
+
+		bytecodeCompiler, err := CompileNsFile(input.Path, compilationFlags)
+		if err == nil {
+			err = writeOutputFile(result.OutputPath, bytecodeCompiler.Bytes)
+		}
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		entry.OutputHash = hashBytes(bytecodeCompiler.Bytes)
+		if len(bytecodeCompiler.IncludedFilePaths) > 0 {
+			entry.IncludedFileHashes = map[string]string{}
+		}
+		for _, includedFilePath := range bytecodeCompiler.IncludedFilePaths {
+			// A file that can't be read has no hash, so the input is compiled again next time
+			includedFileHash := ""
+			if contents, err := ioutil.ReadFile(includedFilePath); err == nil {
+				includedFileHash = hashBytes(contents)
+			}
+			entry.IncludedFileHashes[filepath.ToSlash(includedFilePath)] = includedFileHash
+		}
+		newCacheLock.Lock()
+		newCache.Outputs[cacheKey] = entry
+		newCacheLock.Unlock()
+		return result
+	})
+
+	// Failed files aren't in the new cache, so they're compiled again next time
+	if err := WriteBuildCache(cachePath, newCache); err != nil {
+		return err
+	}
+	return ReportBatch(results, "Compiled")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type buildForTest struct {
+	t               *testing.T
+	sourceDirectory string
+	outputDirectory string
+}
+
+func newBuildForTest(t *testing.T, files map[string]string) buildForTest {
+	directory := t.TempDir()
+	build := buildForTest{t, filepath.Join(directory, "scripts"), filepath.Join(directory, "build")}
+	for path, contents := range files {
+		build.writeSource(path, contents)
+	}
+	return build
+}
+
+func (build buildForTest) writeSource(path string, contents string) {
+	build.t.Helper()
+	path = filepath.Join(build.sourceDirectory, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		build.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		build.t.Fatal(err)
+	}
+}
+
+// Builds, then returns which outputs were written (rather than skipped) by the build.
+func (build buildForTest) run(flags ...string) map[string]bool {
+	build.t.Helper()
+
+	// Outputs that are skipped keep the old modification time
+	longAgo := time.Now().Add(-time.Hour)
+	outputs, _ := filepath.Glob(filepath.Join(build.outputDirectory, "*.qb"))
+	for _, output := range outputs {
+		if err := os.Chtimes(output, longAgo, longAgo); err != nil {
+			build.t.Fatal(err)
+		}
+	}
+
+	args := append([]string{"build", "-o", build.outputDirectory}, flags...)
+	if exitCode := Run(append(args, build.sourceDirectory)); exitCode != exitCode_Success {
+		build.t.Fatalf("Build failed with exit code %d", exitCode)
+	}
+
+	written := map[string]bool{}
+	outputs, _ = filepath.Glob(filepath.Join(build.outputDirectory, "*.qb"))
+	for _, output := range outputs {
+		info, err := os.Stat(output)
+		if err != nil {
+			build.t.Fatal(err)
+		}
+		if info.ModTime().After(longAgo) {
+			written[filepath.Base(output)] = true
+		}
+	}
+	return written
+}
+
+func (build buildForTest) expectWritten(written map[string]bool, expected ...string) {
+	build.t.Helper()
+	if len(written) != len(expected) {
+		build.t.Fatalf("Expected %q to be written, but %v were", expected, written)
+	}
+	for _, name := range expected {
+		if !written[name] {
+			build.t.Fatalf("Expected %q to be written, but %v were", expected, written)
+		}
+	}
+}
+
+func TestBuildSkipsUnchangedFiles(t *testing.T) {
+	build := newBuildForTest(t, map[string]string{"a.ns": "x = 1\n", "b.ns": "y = 2\n"})
+
+	build.expectWritten(build.run(), "a.qb", "b.qb")
+	build.expectWritten(build.run())
+
+	build.writeSource("a.ns", "x = 3\n")
+	build.expectWritten(build.run(), "a.qb")
+	build.expectWritten(build.run())
+
+	build.expectWritten(build.run("-force"), "a.qb", "b.qb")
+	build.expectWritten(build.run("-force"), "a.qb", "b.qb")
+}
+
+func TestBuildRebuildsWhenSettingsChange(t *testing.T) {
+	build := newBuildForTest(t, map[string]string{"a.ns": "x = 1\n"})
+
+	build.expectWritten(build.run("-targetGame", "thug2"), "a.qb")
+	build.expectWritten(build.run("-targetGame", "thug2"))
+	build.expectWritten(build.run("-targetGame", "thps4"), "a.qb")
+	build.expectWritten(build.run("-targetGame", "thps4"))
+
+	build.expectWritten(build.run("-targetGame", "thps4", "-lineNumbers"), "a.qb")
+	build.expectWritten(build.run("-targetGame", "thps4", "-lineNumbers"))
+
+	// Turning them off is the same as the target game's default, so leaving the flag out later doesn't rebuild
+	build.expectWritten(build.run("-targetGame", "thps4", "-lineNumbers=false"), "a.qb")
+	build.expectWritten(build.run("-targetGame", "thps4"))
+}
+
+func TestBuildRebuildsAfterAnotherVersion(t *testing.T) {
+	build := newBuildForTest(t, map[string]string{"a.ns": "x = 1\n"})
+	build.expectWritten(build.run(), "a.qb")
+
+	cachePath := BuildCachePath(build.outputDirectory)
+	cache := ReadBuildCache(cachePath)
+	if len(cache.Outputs) != 1 {
+		t.Fatalf("Expected one output in the cache, got %v", cache.Outputs)
+	}
+	cache.Version = "0.1"
+	cacheBytes, err := json.Marshal(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cachePath, cacheBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	build.expectWritten(build.run(), "a.qb")
+	build.expectWritten(build.run())
+}
+
+func TestBuildRebuildsChangedOutputs(t *testing.T) {
+	build := newBuildForTest(t, map[string]string{"a.ns": "x = 1\n", "b.ns": "y = 2\n"})
+	build.expectWritten(build.run(), "a.qb", "b.qb")
+
+	if err := ioutil.WriteFile(filepath.Join(build.outputDirectory, "a.qb"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(build.outputDirectory, "b.qb")); err != nil {
+		t.Fatal(err)
+	}
+	build.expectWritten(build.run(), "a.qb", "b.qb")
+}
+
+func TestHashInput(t *testing.T) {
+	hash := func(source string, args ...string) string {
+		flagSet := buildCommand.NewFlagSet()
+		flags := AddCompilationFlags(flagSet, nil)
+		if err := flagSet.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return flags.HashInput([]byte(source))
+	}
+
+	original := hash("x = 1\n")
+	if hash("x = 1\n") != original {
+		t.Fatal("Expected the same input to hash the same")
+	}
+	for _, changed := range []string{
+		hash("x = 2\n"),
+		hash("x = 1\n", "-targetGame", "thps4"),
+		hash("x = 1\n", "-removeChecksums"),
+		hash("x = 1\n", "-lineNumbers"),
+		hash("x = 1\n", "-preserveLineNumbers"),
+		hash("x = 1\n", "-newcompiler"),
+	} {
+		if changed == original {
+			t.Fatal("Expected a change to the input to change the hash")
+		}
+	}
+
+	// The game's name is hashed the way the compiler sees it
+	if hash("x = 1\n", "-targetGame", "THUG2") != original {
+		t.Fatal("Expected the target game's name to be case insensitive")
+	}
+}
+
+func TestBuildRebuildsWhenIncludedFilesChange(t *testing.T) {
+	build := newBuildForTest(t, map[string]string{
+		"a.ns":         "include \"../shared.ns\"\nx = 1\n",
+		"b.ns":         "y = 2\n",
+		"../shared.ns": "speed = 10\n",
+	})
+	build.expectWritten(build.run(), "a.qb", "b.qb")
+	build.expectWritten(build.run())
+
+	build.writeSource("../shared.ns", "speed = 20\n")
+	build.expectWritten(build.run(), "a.qb")
+	build.expectWritten(build.run())
+
+	if err := os.Remove(filepath.Join(build.sourceDirectory, "..", "shared.ns")); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := Run([]string{"build", "-o", build.outputDirectory, build.sourceDirectory}); exitCode != exitCode_Failure {
+		t.Fatalf("Expected the build to fail without the included file, got exit code %d", exitCode)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/byxor/NeverScript/compiler"
+	"github.com/byxor/NeverScript/decompiler"
+	"github.com/byxor/NeverScript/newcompiler"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var compileCommand = Command{
+	Name:        "compile",
+	Arguments:   "[flags] <file.ns|directory|glob>...",
+	Description: "Compile NeverScript code into QB files. Directories are searched recursively and, with -o, mirrored into the output directory.",
+	Run:         RunCompile,
+}
+
+// Flags shared by every command that compiles code.
+type CompilationFlags struct {
+	TargetGame          *string
+	RemoveChecksums     *bool
+	LineNumbers         *bool
+	PreserveLineNumbers *bool
+	NewCompiler         *bool
+	flagSet             *flag.FlagSet
+	projectLineNumbers  bool // Whether the project file sets lineNumbers
+}
+
+// The defaults come from the project file, if there is one.
+func AddCompilationFlags(flagSet *flag.FlagSet, project *Project) CompilationFlags {
+	var defaults Project
+	if project != nil {
+		defaults = *project
+	}
+	lineNumbers := false
+	if defaults.LineNumbers != nil {
+		lineNumbers = *defaults.LineNumbers
+	}
+	return CompilationFlags{
+		TargetGame:          flagSet.String("targetGame", project.TargetGame(), fmt.Sprintf("Specify which game to target (%s).", strings.Join(compiler.TargetGameNames(), "/"))),
+		RemoveChecksums:     flagSet.Bool("removeChecksums", defaults.RemoveChecksums, "Remove checksum information from the end of the output."),
+		LineNumbers:         flagSet.Bool("lineNumbers", lineNumbers, "Write new-lines with source line numbers, for in-game debug output (defaults to the target game's setting)."),
+		PreserveLineNumbers: flagSet.Bool("preserveLineNumbers", defaults.PreserveLineNumbers, "Write '// line N' comments out as line numbers (see 'ns decompile -showLineNumbers')."),
+		NewCompiler:         flagSet.Bool("newcompiler", false, "Compile with the new compiler, which supports const, enum and inline script declarations, and for loops."),
+		flagSet:             flagSet,
+		projectLineNumbers:  defaults.LineNumbers != nil,
+	}
+}
+
+// Whether the flag was used, rather than left at its default.
+func flagWasGiven(flagSet *flag.FlagSet, name string) bool {
+	wasGiven := false
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			wasGiven = true
+		}
+	})
+	return wasGiven
+}
+
+// A copy of the flags that targets another game.
+func (flags CompilationFlags) WithTargetGame(targetGame string) CompilationFlags {
+	flags.TargetGame = &targetGame
+	return flags
+}
+
+// Whether -lineNumbers was given (or set by the project file), to override the target game's default.
+func (flags CompilationFlags) LineNumbersWereSet() bool {
+	return flags.projectLineNumbers || (flags.flagSet != nil && flagWasGiven(flags.flagSet, "lineNumbers"))
+}
+
+// Sets up bytecodeCompiler for the target game, then applies the flags on top of its defaults.
+func (flags CompilationFlags) ApplyTo(bytecodeCompiler *compiler.BytecodeCompiler) error {
+	targetGameProfile, found := compiler.FindTargetGameProfile(*flags.TargetGame)
+	if !found {
+		return UsageError{fmt.Sprintf("ERROR - Target game must be %s", strings.Join(compiler.TargetGameNames(), "/"))}
+	}
+
+	targetGameProfile.ApplyTo(bytecodeCompiler)
+	if flags.LineNumbersWereSet() {
+		bytecodeCompiler.WriteLineNumbers = *flags.LineNumbers
+	}
+	bytecodeCompiler.RemoveChecksums = *flags.RemoveChecksums
+	bytecodeCompiler.PreserveLineNumbers = *flags.PreserveLineNumbers
+	return nil
+}
+
+// Fails early on flags that would make every compilation fail.
+func (flags CompilationFlags) Validate() error {
+	if _, found := compiler.FindTargetGameProfile(*flags.TargetGame); !found {
+		return UsageError{fmt.Sprintf("ERROR - Target game must be %s", strings.Join(compiler.TargetGameNames(), "/"))}
+	}
+	if *flags.NewCompiler && *flags.PreserveLineNumbers {
+		return UsageError{"ERROR - -preserveLineNumbers doesn't work with -newcompiler"}
+	}
+	return nil
+}
+
+// Compiles with the compilation flags (e.g. -targetGame), whether the file was given to 'ns compile' or found in a
+// pre spec.
+func CompileNsFile(nsFilePath string, flags CompilationFlags) (*compiler.BytecodeCompiler, error) {
+	var bytecodeCompiler compiler.BytecodeCompiler
+	if err := flags.ApplyTo(&bytecodeCompiler); err != nil {
+		return nil, err
+	}
+
+	compile := func() error {
+		var lexer compiler.Lexer
+		var parser compiler.Parser
+		if compilationError := compiler.CompileToBytes(nsFilePath, &lexer, &parser, &bytecodeCompiler); compilationError != nil {
+			return compilationError.ToError()
+		}
+		return nil
+	}
+	if *flags.NewCompiler {
+		compile = func() error {
+			return compileWithNewCompiler(nsFilePath, &bytecodeCompiler)
+		}
+	}
+
+	compilationChannel := make(chan error, 1)
+	go func() {
+		compilationChannel <- compile()
+	}()
+	select {
+	case err := <-compilationChannel:
+		if err != nil {
+			return nil, err
+		}
+	case <-time.After(3 * time.Second):
+		return nil, InternalError{fmt.Sprintf("ERROR - Compiler took too long on '%s'. It probably went into an infinite loop because of a bug or an unimplemented feature", nsFilePath)}
+	}
+	return &bytecodeCompiler, nil
+}
+
+// Leaves the bytecode and included files in bytecodeCompiler, the same as the old compiler does, so callers don't
+// need to know which compiler was used. The new compiler doesn't write checksum information.
+func compileWithNewCompiler(nsFilePath string, bytecodeCompiler *compiler.BytecodeCompiler) error {
+	program, includedFilePaths, err := newcompiler.ParseFile(nsFilePath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+	qb, err := newcompiler.ProduceQbWithSettings(program, newcompiler.Settings{
+		WriteLineNumbers: bytecodeCompiler.WriteLineNumbers,
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+	bytecodeCompiler.Bytes = qb
+	bytecodeCompiler.IncludedFilePaths = includedFilePaths
+	return nil
+}
+
+// Like CompileNsFile, in the shape pre_generator.PreSettings.Compile expects.
+func (flags CompilationFlags) Compile(nsFilePath string) ([]byte, error) {
+	bytecodeCompiler, err := CompileNsFile(nsFilePath, flags)
+	if err != nil {
+		return nil, err
+	}
+	return bytecodeCompiler.Bytes, nil
+}
+
+func RunCompile(command Command, args []string) error {
+	project, err := FindProject()
+	if err != nil {
+		return err
+	}
+	flagSet := command.NewFlagSet()
+	outputFileName := flagSet.String("o", "", "Specify the output file name, or the output directory when compiling several files (defaults to the input file name with a .qb extension).")
+	compilationFlags := AddCompilationFlags(flagSet, project)
+	showHexDump := flagSet.Bool("showHexDump", false, "Display the compiled bytecode in hex format.")
+	showDecompiledRoq := flagSet.Bool("showDecompiledRoq", false, "Display the compiled bytecode in roq's decompiled format.")
+	jobs := flagSet.Int("jobs", runtime.NumCPU(), "Specify how many files to compile at once.")
+	positionalArgs, err := command.ParseFlags(flagSet, args, 1, -1)
+	if err != nil {
+		return err
+	}
+	if err := compilationFlags.Validate(); err != nil {
+		return err
+	}
+
+	inputs, isBatch, err := FindInputFiles(positionalArgs, ".ns")
+	if err != nil {
+		return err
+	}
+	if isBatch {
+		if *showHexDump || *showDecompiledRoq {
+			return UsageError{"ERROR - -showHexDump and -showDecompiledRoq only work when compiling a single file"}
+		}
+		fmt.Printf("\nCompiling %d file(s)...\n", len(inputs))
+		results := RunBatch(inputs, *jobs, func(input InputFile) BatchResult {
+			result := BatchResult{Input: input, OutputPath: input.OutputPath(*outputFileName, ".qb")}
+			bytecodeCompiler, err := CompileNsFile(input.Path, compilationFlags)
+			if err == nil {
+				err = writeOutputFile(result.OutputPath, bytecodeCompiler.Bytes)
+			}
+			result.Err = err
+			return result
+		})
+		return ReportBatch(results, "Compiled")
+	}
+	fileToCompile := inputs[0].Path
+
+	if *outputFileName == "" {
+		*outputFileName = WithQbExtension(fileToCompile)
+	}
+
+	bytecodeCompiler, err := CompileNsFile(fileToCompile, compilationFlags)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*outputFileName, bytecodeCompiler.Bytes, 0644); err != nil {
+		return errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+	fmt.Printf("\n  Created '%s'.\n", *outputFileName)
+
+	if *showHexDump {
+		fmt.Printf("\n%s", hex.Dump(bytecodeCompiler.Bytes))
+	}
+
+	if *showDecompiledRoq {
+		fmt.Println("\nRoq decompiler output:")
+
+		decompiledRoq, err := decompiler.DecompileRoq(bytecodeCompiler.Bytes)
+		fmt.Println("\n" + strings.TrimSpace(decompiledRoq))
+		if err != nil {
+			fmt.Printf("\nWARNING - %s\n", err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/byxor/NeverScript/decompiler"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+)
+
+var decompileCommand = Command{
+	Name:        "decompile",
+	Arguments:   "[flags] <file.qb|directory|glob>...",
+	Description: "Decompile QB files into NeverScript code. Directories are searched recursively and, with -o, mirrored into the output directory.",
+	Run:         RunDecompile,
+}
+
+var disassembleCommand = Command{
+	Name:        "disasm",
+	Arguments:   "[flags] <file.qb>",
+	Description: "List every instruction in a QB file with its offset, bytes and decoded operand.",
+	Run:         RunDisassembler,
+}
+
+var diffCommand = Command{
+	Name:        "diff",
+	Arguments:   "<a.qb> <b.qb>",
+	Description: "Show which globals and scripts were added, removed or changed between two QB files.",
+	Run:         RunDiff,
+}
+
+func RunDecompile(command Command, args []string) error {
+	flagSet := command.NewFlagSet()
+	outputFileName := flagSet.String("o", "", "Specify the output file name, or the output directory when decompiling several files (defaults to the input file name with a .ns extension).")
+	showCode := flagSet.Bool("showCode", false, "Display the decompiled code as text.")
+	tolerant := flagSet.Bool("tolerant", false, "Emit unrecognised bytes as raw 'bytes(...)' blocks instead of failing.")
+	showLineNumbers := flagSet.Bool("showLineNumbers", false, "Annotate lines with their original line numbers as '// line N' comments.")
+	jobs := flagSet.Int("jobs", runtime.NumCPU(), "Specify how many files to decompile at once.")
+	positionalArgs, err := command.ParseFlags(flagSet, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	checksumNames, err := projectChecksumNames()
+	if err != nil {
+		return err
+	}
+	settings := decompiler.Settings{
+		Tolerant:              *tolerant,
+		LineNumberAnnotations: *showLineNumbers,
+		ChecksumNames:         checksumNames,
+	}
+
+	inputs, isBatch, err := FindInputFiles(positionalArgs, ".qb")
+	if err != nil {
+		return err
+	}
+	if isBatch {
+		if *showCode {
+			return UsageError{"ERROR - -showCode only works when decompiling a single file"}
+		}
+		fmt.Printf("\nDecompiling %d file(s)...\n", len(inputs))
+		results := RunBatch(inputs, *jobs, func(input InputFile) BatchResult {
+			result := BatchResult{Input: input, OutputPath: input.OutputPath(*outputFileName, ".ns")}
+			decompiledCode, skippedRegions, err := decompileFile(input.Path, settings)
+			if err == nil {
+				err = writeOutputFile(result.OutputPath, []byte(decompiledCode))
+			}
+			if len(skippedRegions) > 0 {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("Skipped %d region(s) that couldn't be decompiled", len(skippedRegions)))
+			}
+			result.Err = err
+			return result
+		})
+		return ReportBatch(results, "Decompiled")
+	}
+	fileToDecompile := inputs[0].Path
+
+	decompiledCode, skippedRegions, err := decompileFile(fileToDecompile, settings)
+	if err != nil {
+		return err
+	}
+
+	if *outputFileName == "" {
+		*outputFileName = WithNsExtension(fileToDecompile)
+	}
+	if err := ioutil.WriteFile(*outputFileName, []byte(decompiledCode), 0644); err != nil {
+		return errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+
+	fmt.Printf("\n  Created '%s'.\n", *outputFileName)
+
+	if *showCode {
+		fmt.Printf("\n%s", decompiledCode)
+	}
+
+	if len(skippedRegions) > 0 {
+		fmt.Printf("\n  WARNING - Skipped %d region(s) that couldn't be decompiled:\n", len(skippedRegions))
+		for _, skippedRegion := range skippedRegions {
+			fmt.Printf("    0x%x-0x%x (%d bytes)\n", skippedRegion.Offset, skippedRegion.Offset+skippedRegion.Size-1, skippedRegion.Size)
+		}
+	}
+
+	return nil
+}
+
+func decompileFile(fileToDecompile string, settings decompiler.Settings) (string, []decompiler.SkippedRegion, error) {
+	qb, err := readFile(fileToDecompile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	decompiledCode, skippedRegions, err := decompiler.DecompileWithSettings(qb, settings)
+	if err != nil {
+		return "", skippedRegions, err
+	}
+	return fmt.Sprintf("// %s decompiled with ns %s\n%s", filepath.Base(fileToDecompile), version, decompiledCode), skippedRegions, nil
+}
+
+// Names from the project's checksum dictionaries, for checksums that QBs don't have names for.
+func projectChecksumNames() (map[uint32]string, error) {
+	project, err := FindProject()
+	if err != nil {
+		return nil, err
+	}
+	return project.ChecksumNames()
+}
+
+func RunDisassembler(command Command, args []string) error {
+	flagSet := command.NewFlagSet()
+	outputFileName := flagSet.String("o", "", "Specify the output file name (prints to the terminal by default).")
+	positionalArgs, err := command.ParseFlags(flagSet, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	fileToDisassemble := positionalArgs[0]
+
+	qb, err := readFile(fileToDisassemble)
+	if err != nil {
+		return err
+	}
+
+	checksumNames, err := projectChecksumNames()
+	if err != nil {
+		return err
+	}
+	instructions, err := decompiler.DisassembleWithChecksumNames(qb, checksumNames)
+	listing := decompiler.FormatDisassembly(instructions)
+	if *outputFileName != "" {
+		if writeErr := ioutil.WriteFile(*outputFileName, []byte(listing), 0644); writeErr != nil {
+			return errors.New(fmt.Sprintf("ERROR - %s", writeErr))
+		}
+		fmt.Printf("\n  Created '%s'.\n", *outputFileName)
+	} else {
+		fmt.Print(listing)
+	}
+
+	// Show what could be disassembled before reporting the problem
+	return err
+}
+
+func RunDiff(command Command, args []string) error {
+	flagSet := command.NewFlagSet()
+	positionalArgs, err := command.ParseFlags(flagSet, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	fileA, fileB := positionalArgs[0], positionalArgs[1]
+
+	qbA, err := readFile(fileA)
+	if err != nil {
+		return err
+	}
+	qbB, err := readFile(fileB)
+	if err != nil {
+		return err
+	}
+
+	report, err := decompiler.Diff(qbA, qbB, fileA, fileB)
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	return nil
+}
@@ -1,19 +1,14 @@
 package main
 
 import (
-	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/byxor/NeverScript/compiler"
-	"github.com/byxor/NeverScript/decompiler"
-	"github.com/byxor/NeverScript/pre_generator"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
-	"time"
 )
 
 const (
@@ -28,191 +23,289 @@ const (
 ----------------------------------------------------
 `
 
-	usage = `
-COMPILATION:
-    -c                 (required string)  Specify a file to compile (.ns).
-    -o                 (optional string)  Specify the output file name (.qb).
-    -targetGame        (optional string)  Specify which game to target (defaults to "thug2").
-    -removeChecksums   (optional flag)    Removes checksum information from end of output.
-    -showHexDump       (optional flag)    Display the compiled bytecode in hex format.
-    -showDecompiledRoq (optional flag)    Display output from roq decompiler (roq.exe must be in your PATH).
+	version = "0.9-IN-PROGRESS"
+)
 
-PRE GENERATION:
-    -p                 (required string)  Specify a pre spec file (.ps).
-    -showHexDump       (optional flag)    Display the pre bytes in hex format.
+// Exit codes
+const (
+	exitCode_Success       = 0
+	exitCode_Failure       = 1 // Something was wrong with the input, e.g. code that doesn't compile or a missing file
+	exitCode_UsageError    = 2 // The command was used incorrectly, e.g. an unknown flag or a missing argument
+	exitCode_InternalError = 3 // A bug in ns, e.g. a crash or the compiler getting stuck
+)
 
-DECOMPILATION:
-    -d                 (required string)  Specify a file to decompile (.qb).
-    -o                 (optional string)  Specify the output file name (.ns).
-    -showCode          (optional flag)    Display the decompiled code as text.
+type UsageError struct {
+	Message string
+}
 
-`
+func (self UsageError) Error() string {
+	return self.Message
+}
 
-	version = "0.9-IN-PROGRESS"
-)
+type InternalError struct {
+	Message string
+}
 
-type CommandLineArguments struct {
-	FileToCompile     *string
-	FileToDecompile   *string
-	PreSpecFile       *string
-	OutputFileName    *string
-	TargetGame        *string
-	ShowHexDump       *bool
-	ShowCode          *bool
-	RemoveChecksums   *bool
-	ShowDecompiledRoq *bool
+func (self InternalError) Error() string {
+	return self.Message
 }
 
-func main() {
-	arguments := ParseCommandLineArguments()
-	if err := RunNeverscript(arguments); err != nil {
-		fmt.Println()
-	    fmt.Println(err.Error())
-		os.Exit(1)
-	}
-}
-
-func ParseCommandLineArguments() CommandLineArguments {
-	args := CommandLineArguments{
-		FileToCompile:     flag.String("c", "", ""),
-		FileToDecompile:   flag.String("d", "", ""),
-		PreSpecFile:       flag.String("p", "", ""),
-		OutputFileName:    flag.String("o", "", ""),
-		TargetGame:        flag.String("targetGame", "thug2", ""),
-		ShowHexDump:       flag.Bool("showHexDump", false, ""),
-		ShowCode:          flag.Bool("showCode", false, ""),
-		ShowDecompiledRoq: flag.Bool("showDecompiledRoq", false, ""),
-		RemoveChecksums:   flag.Bool("removeChecksums", false, ""),
-	}
-	flag.Parse()
-	return args
-}
-
-func RunNeverscript(arguments CommandLineArguments) error {
-	argumentsWereSupplied := false
-
-	//fileToDecompile := `C:\Program Files (x86)\Aspyr\Tony Hawks Pro Skater 4\Game\data\scripts\groundtricks.qb`
-	////fileToDecompile := `C:\Program Files (x86)\Aspyr\Tony Hawks Pro Skater 4\Game\data\scripts\temp.qb`
-	////fileToDecompile := `./temp.qb`
-	//showCode := true
-	////outputFileName := `./temp_d.ns`
-	//arguments.FileToDecompile = &fileToDecompile
-	//arguments.ShowCode = &showCode
-	////arguments.OutputFileName = &outputFileName
-
-	if *arguments.FileToCompile != "" {
-		argumentsWereSupplied = true
-
-		outputFileName := *arguments.OutputFileName
-		if outputFileName == "" {
-			outputFileName = WithQbExtension(*arguments.FileToCompile)
-		}
+type Command struct {
+	Name        string
+	Arguments   string // e.g. "[flags] <file.ns>"
+	Description string
+	Run         func(command Command, args []string) error
+	Subcommands []Command
+}
 
-		var lexer compiler.Lexer
-		var parser compiler.Parser
-		var bytecodeCompiler compiler.BytecodeCompiler
-		bytecodeCompiler.TargetGame = strings.ToLower(*arguments.TargetGame)
-		bytecodeCompiler.RemoveChecksums = *arguments.RemoveChecksums
-
-		if bytecodeCompiler.TargetGame != "thps3" &&
-			bytecodeCompiler.TargetGame != "thps4" &&
-			bytecodeCompiler.TargetGame != "thug1" &&
-			bytecodeCompiler.TargetGame != "thug2" {
-			return errors.New("ERROR - Target game must be thps3/thps4/thug1/thug2")
-		}
+func (command Command) FullName() string {
+	return "ns " + command.Name
+}
+
+// Creates the command's flag set. Problems are reported by ParseFlags rather than by the flag package.
+func (command Command) NewFlagSet() *flag.FlagSet {
+	flagSet := flag.NewFlagSet(command.FullName(), flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	flagSet.Usage = func() {}
+	return flagSet
+}
 
-		compilationChannel := make(chan compiler.Error, 1)
-		go func() {
-			compilationError := compiler.Compile(*arguments.FileToCompile, outputFileName, &lexer, &parser, &bytecodeCompiler)
-			compilationChannel <- compilationError
-		}()
-		var compilationError compiler.Error
-		select {
-		case result := <-compilationChannel:
-		    compilationError = result
-		case <-time.After(3 * time.Second):
-			return errors.New("ERROR - Compiler took too long. It probably went into an infinite loop because of a bug or an unimplemented feature")
-			fmt.Println("\nWARNING - Roq decompiler froze. Some QB cannot be decompiled, e.g. adjacent line ending bytes (0x01 0x01)")
+func (command Command) PrintHelp(flagSet *flag.FlagSet) {
+	fmt.Printf("\nUsage: %s %s\n\n%s\n", command.FullName(), command.Arguments, command.Description)
+	hasFlags := false
+	flagSet.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Printf("\nFlags:\n")
+		flagSet.SetOutput(os.Stdout)
+		flagSet.PrintDefaults()
+		flagSet.SetOutput(ioutil.Discard)
+	}
+	fmt.Println()
+}
+
+// Parses the command's flags, allowing them after positional arguments too (e.g. 'in.prx overrides -o out.prx').
+// Everything after '--' is a positional argument, even if it starts with '-'.
+// Fails unless there are between minimum and maximum positional arguments (-1 for no maximum).
+func (command Command) ParseFlags(flagSet *flag.FlagSet, args []string, minimum int, maximum int) ([]string, error) {
+	var positionalArgs []string
+	for {
+		if err := flagSet.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				command.PrintHelp(flagSet)
+				return nil, err
+			}
+			return nil, UsageError{fmt.Sprintf("ERROR - %s (see '%s -h')", err, command.FullName())}
 		}
-		if compilationError != nil {
-			return compilationError.ToError()
+		parsedArgs := args[:len(args)-flagSet.NArg()]
+		args = flagSet.Args()
+		if len(parsedArgs) > 0 && parsedArgs[len(parsedArgs)-1] == "--" {
+			positionalArgs = append(positionalArgs, args...)
+			break
 		}
-		fmt.Printf("\n  Created '%s'.\n", outputFileName)
-
-		if *arguments.ShowHexDump {
-			fmt.Printf("\n%s", hex.Dump(bytecodeCompiler.Bytes))
+		if len(args) == 0 {
+			break
 		}
+		positionalArgs = append(positionalArgs, args[0])
+		args = args[1:]
+	}
 
-		if *arguments.ShowDecompiledRoq {
-			fmt.Println("\nRoq decompiler output:")
+	if len(positionalArgs) < minimum || (maximum >= 0 && len(positionalArgs) > maximum) {
+		return nil, UsageError{fmt.Sprintf("ERROR - Usage: %s %s (see '%s -h')", command.FullName(), command.Arguments, command.FullName())}
+	}
+	return positionalArgs, nil
+}
 
-			roqChannel := make(chan string, 1)
+var commands []Command
+
+func init() {
+	// Assigned here because the help command refers back to the list of commands
+	commands = []Command{
+		compileCommand,
+		buildCommand,
+		watchCommand,
+		decompileCommand,
+		disassembleCommand,
+		diffCommand,
+		preCommand,
+		{
+			Name:        "help",
+			Arguments:   "[command]",
+			Description: "Show the commands, or the help for one of them.",
+			Run:         RunHelp,
+		},
+		{
+			Name:        "version",
+			Arguments:   "",
+			Description: "Show which version of ns this is.",
+			Run: func(command Command, args []string) error {
+				fmt.Printf("ns %s\n", version)
+				return nil
+			},
+		},
+	}
+}
 
-			go func() {
-				roqCmd := exec.Command("roq.exe", "-d", outputFileName)
-				decompiledCode, _ := roqCmd.Output()
-				roqChannel <- "\n" + strings.TrimSpace(string(decompiledCode))
-			}()
+func main() {
+	os.Exit(Run(os.Args[1:]))
+}
 
-			select {
-			case decompiledRoq := <-roqChannel:
-				fmt.Println(decompiledRoq)
-			case <-time.After(3 * time.Second):
-				fmt.Println("\nWARNING - Roq decompiler froze. Some QB cannot be decompiled, e.g. adjacent line ending bytes (0x01 0x01)")
-			}
+// Runs ns with the given arguments and returns the exit code.
+func Run(args []string) (exitCode int) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			fmt.Fprintf(os.Stderr, "\nINTERNAL ERROR - %v\n\n%s\nPlease report this as a bug.\n", recovered, debug.Stack())
+			exitCode = exitCode_InternalError
 		}
-	} else if *arguments.FileToDecompile != "" {
-		argumentsWereSupplied = true
+	}()
 
-		qb, err := ioutil.ReadFile(*arguments.FileToDecompile)
-		if err != nil {
-			return err
-		}
+	if legacyArgs, isLegacy := TranslateLegacyArguments(args); isLegacy {
+		args = legacyArgs
+	}
 
-		decompiledCode, err := decompiler.Decompile(qb)
-		if err != nil {
-			return err
-		}
-		decompiledCode = fmt.Sprintf("// %s decompiled with ns %s\n%s", filepath.Base(*arguments.FileToDecompile), version, decompiledCode)
+	if len(args) == 0 {
+		printUsage()
+		return exitCode_Success
+	}
 
-		outputFileName := *arguments.OutputFileName
-		if outputFileName == "" {
-			outputFileName = WithNsExtension(*arguments.FileToDecompile)
-		}
-		ioutil.WriteFile(outputFileName, []byte(decompiledCode), 0644)
+	command, subcommandArgs, err := findCommand(commands, args, "")
+	if err == nil {
+		err = command.Run(command, subcommandArgs)
+	}
+
+	if err == nil || err == flag.ErrHelp {
+		return exitCode_Success
+	}
 
-		fmt.Printf("\n  Created '%s'.\n", outputFileName)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, err.Error())
+	switch err.(type) {
+	case UsageError:
+		return exitCode_UsageError
+	case InternalError:
+		return exitCode_InternalError
+	}
+	return exitCode_Failure
+}
 
-		if *arguments.ShowCode {
-			fmt.Printf("\n%s", decompiledCode)
+func findCommand(available []Command, args []string, parentName string) (Command, []string, error) {
+	if len(args) == 0 {
+		return Command{}, nil, UsageError{fmt.Sprintf("ERROR - Specify a command: %s (see 'ns help %s')", commandNames(available), parentName)}
+	}
+	name := strings.TrimSpace(parentName + " " + args[0])
+	for _, command := range available {
+		if command.Name != name {
+			continue
 		}
-	} else if *arguments.PreSpecFile != "" {
-		argumentsWereSupplied = true
+		if len(command.Subcommands) > 0 {
+			return findCommand(command.Subcommands, args[1:], command.Name)
+		}
+		return command, args[1:], nil
+	}
+	return Command{}, nil, UsageError{fmt.Sprintf("ERROR - Unknown command '%s' (expected %s)", name, commandNames(available))}
+}
+
+func commandNames(available []Command) string {
+	var names []string
+	for _, command := range available {
+		names = append(names, "'"+command.Name[strings.LastIndex(command.Name, " ")+1:]+"'")
+	}
+	return strings.Join(names, ", ")
+}
+
+func printUsage() {
+	fmt.Println(banner[1:])
+	fmt.Printf("Release %s\n\n", version)
+	fmt.Printf("Usage: ns <command> [flags] [arguments]\n\n")
+	fmt.Printf("Commands:\n")
+	printCommands(commands)
+	fmt.Printf("\nUse 'ns help <command>' (or 'ns <command> -h') to see a command's flags.\n\n")
+}
 
-		outputFilename := *arguments.OutputFileName
-		if outputFilename == "" {
-			outputFilename = WithPrxExtension(*arguments.PreSpecFile)
+func printCommands(available []Command) {
+	for _, command := range available {
+		if len(command.Subcommands) > 0 {
+			printCommands(command.Subcommands)
+			continue
 		}
+		fmt.Printf("    %-16s %s\n", command.Name, command.Description)
+	}
+}
 
-		fmt.Printf("\nGenerating pre file from spec '%s'...\n", *arguments.PreSpecFile)
-		preSpec := pre_generator.ParsePreSpec(*arguments.PreSpecFile)
-		pre := pre_generator.MakePre(preSpec)
-		ioutil.WriteFile(*arguments.PreSpecFile, pre, 0644)
-		fmt.Printf("  Created '%s'.\n\n", outputFilename)
+func RunHelp(command Command, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" {
+		printUsage()
+		return nil
+	}
 
-		if *arguments.ShowHexDump {
-			fmt.Printf("Hex dump:\n%s\n", hex.Dump(pre))
+	// Commands like 'pre' are only a group of other commands
+	for _, group := range commands {
+		if len(args) == 1 && group.Name == args[0] && len(group.Subcommands) > 0 {
+			fmt.Printf("\n%s\n\nCommands:\n", group.Description)
+			printCommands(group.Subcommands)
+			fmt.Println()
+			return nil
 		}
 	}
 
-	if !argumentsWereSupplied {
-		fmt.Println(banner[1:])
-		fmt.Printf("Release %s\n\n", version)
-		fmt.Printf("Usage of %s:\n", os.Args[0])
-		fmt.Printf(usage)
+	commandToExplain, _, err := findCommand(commands, args, "")
+	if err != nil {
+		return err
+	}
+	// Running a command with -h prints its help, without needing to know which flags it has
+	return commandToExplain.Run(commandToExplain, []string{"-h"})
+}
+
+// The flags from before there were commands (e.g. 'ns -c file.ns') still work. They're turned into the equivalent
+// command, e.g. 'ns compile file.ns'.
+func TranslateLegacyArguments(args []string) ([]string, bool) {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "-") {
+		return nil, false
+	}
+
+	flagSet := flag.NewFlagSet("ns", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	fileToCompile := flagSet.String("c", "", "")
+	fileToDecompile := flagSet.String("d", "", "")
+	preSpecFile := flagSet.String("p", "", "")
+	for _, name := range []string{"o", "targetGame"} {
+		flagSet.String(name, "", "")
+	}
+	for _, name := range []string{"showHexDump", "showCode", "showDecompiledRoq", "removeChecksums", "tolerant", "showLineNumbers", "preserveLineNumbers", "lineNumbers", "compress"} {
+		flagSet.Bool(name, false, "")
+	}
+	if err := flagSet.Parse(args); err != nil {
+		// Let the command report the problem
+		return nil, false
+	}
+
+	var translatedArgs []string
+	var file string
+	switch {
+	case *fileToCompile != "":
+		translatedArgs, file = []string{"compile"}, *fileToCompile
+	case *fileToDecompile != "":
+		translatedArgs, file = []string{"decompile"}, *fileToDecompile
+	case *preSpecFile != "":
+		translatedArgs, file = []string{"pre", "build"}, *preSpecFile
+	default:
+		return []string{}, true
 	}
 
-	return nil
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name != "c" && f.Name != "d" && f.Name != "p" {
+			translatedArgs = append(translatedArgs, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+		}
+	})
+	translatedArgs = append(translatedArgs, "--", file)
+	return append(translatedArgs, flagSet.Args()...), true
+}
+
+func readFile(path string) ([]byte, error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+	return fileBytes, nil
 }
 
 func WithQbExtension(fileName string) string {
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"github.com/byxor/NeverScript/compiler"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Adds a command for the length of the test.
+func addCommandForTest(t *testing.T, command Command) {
+	t.Helper()
+	originalCommands := commands
+	commands = append(append([]Command{}, commands...), command)
+	t.Cleanup(func() { commands = originalCommands })
+}
+
+func TestRunExitCodes(t *testing.T) {
+	directory := t.TempDir()
+	validFile := filepath.Join(directory, "valid.ns")
+	invalidFile := filepath.Join(directory, "invalid.ns")
+	if err := ioutil.WriteFile(validFile, []byte("x = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(invalidFile, []byte("x = = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	addCommandForTest(t, Command{Name: "test-panic", Run: func(Command, []string) error { panic("oops") }})
+	addCommandForTest(t, Command{Name: "test-internal-error", Run: func(Command, []string) error { return InternalError{"ERROR - stuck"} }})
+	addCommandForTest(t, Command{Name: "test-error", Run: func(Command, []string) error { return errors.New("ERROR - bad input") }})
+
+	for _, test := range []struct {
+		args     []string
+		exitCode int
+	}{
+		{[]string{}, exitCode_Success},
+		{[]string{"version"}, exitCode_Success},
+		{[]string{"compile", "-h"}, exitCode_Success},
+		{[]string{"compile", validFile}, exitCode_Success},
+		{[]string{"-c", validFile}, exitCode_Success},
+
+		// The input was wrong
+		{[]string{"compile", invalidFile}, exitCode_Failure},
+		{[]string{"compile", filepath.Join(directory, "missing.ns")}, exitCode_Failure},
+		{[]string{"test-error"}, exitCode_Failure},
+
+		// The command was used wrong
+		{[]string{"bogus"}, exitCode_UsageError},
+		{[]string{"pre"}, exitCode_UsageError},
+		{[]string{"compile"}, exitCode_UsageError},
+		{[]string{"compile", "-showHexDump", validFile, validFile}, exitCode_UsageError},
+		{[]string{"compile", "-bogus", validFile}, exitCode_UsageError},
+		{[]string{"compile", "-targetGame", "bogus", validFile}, exitCode_UsageError},
+		{[]string{"compile", "-newcompiler", "-preserveLineNumbers", validFile}, exitCode_UsageError},
+
+		// ns went wrong
+		{[]string{"test-panic"}, exitCode_InternalError},
+		{[]string{"test-internal-error"}, exitCode_InternalError},
+	} {
+		if exitCode := Run(test.args); exitCode != test.exitCode {
+			t.Errorf("Expected exit code %d for %q, got %d", test.exitCode, test.args, exitCode)
+		}
+	}
+}
+
+func TestTranslateLegacyArguments(t *testing.T) {
+	for _, test := range []struct {
+		args           []string
+		translatedArgs []string
+		isLegacy       bool
+	}{
+		{[]string{"-c", "file.ns"}, []string{"compile", "--", "file.ns"}, true},
+		{[]string{"-c", "file.ns", "-targetGame", "thps4", "-lineNumbers"}, []string{"compile", "-lineNumbers=true", "-targetGame=thps4", "--", "file.ns"}, true},
+		{[]string{"-d", "file.qb", "-o", "out.ns", "-showCode"}, []string{"decompile", "-o=out.ns", "-showCode=true", "--", "file.qb"}, true},
+		{[]string{"-compress", "-p", "spec.ps"}, []string{"pre", "build", "-compress=true", "--", "spec.ps"}, true},
+
+		// File names starting with '-' stay file names
+		{[]string{"-c", "-file.ns"}, []string{"compile", "--", "-file.ns"}, true},
+
+		// Nothing to do, so ns shows its usage
+		{[]string{"-showCode"}, []string{}, true},
+
+		// Not legacy, or not valid legacy flags (which the command reports)
+		{[]string{}, nil, false},
+		{[]string{"compile", "-c", "file.ns"}, nil, false},
+		{[]string{"-bogus", "file.ns"}, nil, false},
+	} {
+		translatedArgs, isLegacy := TranslateLegacyArguments(test.args)
+		if isLegacy != test.isLegacy || !reflect.DeepEqual(translatedArgs, test.translatedArgs) {
+			t.Errorf("Expected %q (%t) for %q, got %q (%t)", test.translatedArgs, test.isLegacy, test.args, translatedArgs, isLegacy)
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	command := Command{Name: "test", Arguments: "<a> [b]"}
+	parse := func(args ...string) (string, []string, error) {
+		flagSet := command.NewFlagSet()
+		outputFileName := flagSet.String("o", "", "")
+		positionalArgs, err := command.ParseFlags(flagSet, args, 1, 2)
+		return *outputFileName, positionalArgs, err
+	}
+
+	for _, test := range []struct {
+		args           []string
+		outputFileName string
+		positionalArgs []string
+	}{
+		{[]string{"a"}, "", []string{"a"}},
+		{[]string{"-o", "out", "a", "b"}, "out", []string{"a", "b"}},
+		{[]string{"a", "-o", "out", "b"}, "out", []string{"a", "b"}},
+		{[]string{"a", "b", "-o=out"}, "out", []string{"a", "b"}},
+
+		// Flags aren't parsed after '--'
+		{[]string{"--", "-o", "out"}, "", []string{"-o", "out"}},
+		{[]string{"-o", "out", "--", "-a"}, "out", []string{"-a"}},
+		{[]string{"a", "--", "-o"}, "", []string{"a", "-o"}},
+		{[]string{"a", "--", "--"}, "", []string{"a", "--"}},
+	} {
+		outputFileName, positionalArgs, err := parse(test.args...)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", test.args, err)
+		} else if outputFileName != test.outputFileName || !reflect.DeepEqual(positionalArgs, test.positionalArgs) {
+			t.Errorf("Expected -o %q and %q for %q, got -o %q and %q", test.outputFileName, test.positionalArgs, test.args, outputFileName, positionalArgs)
+		}
+	}
+
+	for _, args := range [][]string{{}, {"a", "b", "c"}, {"a", "-bogus"}, {"a", "-o"}, {"--"}} {
+		if _, _, err := parse(args...); err == nil {
+			t.Errorf("Expected a usage error for %q", args)
+		} else if _, isUsageError := err.(UsageError); !isUsageError {
+			t.Errorf("Expected a usage error for %q, got %#v", args, err)
+		}
+	}
+
+	if _, _, err := parse("-h"); err != flag.ErrHelp {
+		t.Errorf("Expected flag.ErrHelp for -h, got %#v", err)
+	}
+}
+
+func TestLineNumbersFlagOverridesTargetGame(t *testing.T) {
+	originalProfiles := compiler.TargetGameProfiles
+	compiler.TargetGameProfiles = []compiler.TargetGameProfile{
+		{Name: "thps4", WriteLineNumbers: true},
+		{Name: "thug2"},
+	}
+	t.Cleanup(func() { compiler.TargetGameProfiles = originalProfiles })
+
+	for _, test := range []struct {
+		args             []string
+		writeLineNumbers bool
+	}{
+		{[]string{"-targetGame", "thps4"}, true},
+		{[]string{"-targetGame", "thps4", "-lineNumbers=false"}, false},
+		{[]string{"-targetGame", "thug2"}, false},
+		{[]string{"-targetGame", "thug2", "-lineNumbers"}, true},
+	} {
+		flagSet := compileCommand.NewFlagSet()
+		flags := AddCompilationFlags(flagSet, nil)
+		if err := flagSet.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		var bytecodeCompiler compiler.BytecodeCompiler
+		if err := flags.ApplyTo(&bytecodeCompiler); err != nil {
+			t.Fatal(err)
+		}
+		if bytecodeCompiler.WriteLineNumbers != test.writeLineNumbers {
+			t.Errorf("Expected WriteLineNumbers to be %t for %q", test.writeLineNumbers, test.args)
+		}
+	}
+}
+
+func TestCompileWithNewCompiler(t *testing.T) {
+	directory := t.TempDir()
+	for name, contents := range map[string]string{
+		"main.ns":     "include \"enums.ns\"\nconst SPEED = 10\n\nscript Foo {\n    x = (SPEED * 2)\n    y = SkaterState.Air\n}\n",
+		"enums.ns":    "enum SkaterState : int { Skating, Air }\n",
+		"expected.ns": "script Foo {\n    x = 20\n    y = 1\n}\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compile := func(name string, args ...string) (*compiler.BytecodeCompiler, error) {
+		flagSet := compileCommand.NewFlagSet()
+		flags := AddCompilationFlags(flagSet, nil)
+		if err := flagSet.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return CompileNsFile(filepath.Join(directory, name), flags)
+	}
+
+	bytecodeCompiler, err := compile("main.ns", "-newcompiler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := compile("expected.ns", "-newcompiler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The declarations leave their line breaks behind
+	if !bytes.Equal(bytes.TrimLeft(bytecodeCompiler.Bytes, "\x01"), expected.Bytes) {
+		t.Errorf("Expected:\n% x\nGot:\n% x", expected.Bytes, bytecodeCompiler.Bytes)
+	}
+	if expectedPaths := []string{filepath.Join(directory, "enums.ns")}; !reflect.DeepEqual(bytecodeCompiler.IncludedFilePaths, expectedPaths) {
+		t.Errorf("Expected the included files to be %q, got %q", expectedPaths, bytecodeCompiler.IncludedFilePaths)
+	}
+
+	// -lineNumbers is passed on
+	withLineNumbers, err := compile("expected.ns", "-newcompiler", "-lineNumbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(withLineNumbers.Bytes, expected.Bytes) {
+		t.Error("Expected -lineNumbers to change the output of the new compiler")
+	}
+
+	// Errors look like the old compiler's
+	if err := ioutil.WriteFile(filepath.Join(directory, "bad.ns"), []byte("enum Letter { A }\nx = Letter.B\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compile("bad.ns", "-newcompiler"); err == nil || !strings.HasPrefix(err.Error(), "ERROR - bad.ns: ") {
+		t.Errorf("Expected an error starting with 'ERROR - bad.ns: ', got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/byxor/NeverScript/pre_generator"
+	"os"
+)
+
+var preCommand = Command{
+	Name:        "pre",
+	Description: "Build, inspect and modify PRE/PRX archives.",
+	Subcommands: []Command{
+		{
+			Name:        "pre build",
+			Arguments:   "[flags] [spec.ps]",
+			Description: "Build a pre file from a pre spec, or the pres in the project file. Source code (.ns) in the spec is compiled using the compilation flags.",
+			Run:         RunPreBuild,
+		},
+		{
+			Name:        "pre list",
+			Arguments:   "<file.prx>",
+			Description: "List the items inside a pre file.",
+			Run:         RunPreList,
+		},
+		{
+			Name:        "pre extract",
+			Arguments:   "[flags] <file.prx>",
+			Description: "Write the items inside a pre file to disk.",
+			Run:         RunPreExtract,
+		},
+		{
+			Name:        "pre repack",
+			Arguments:   "<in.prx> <dir> -o <out.prx>",
+			Description: "Replace or add items using the files in a directory, keeping the rest byte-identical.",
+			Run:         RunPreRepack,
+		},
+	},
+}
+
+func RunPreBuild(command Command, args []string) error {
+	project, err := FindProject()
+	if err != nil {
+		return err
+	}
+	flagSet := command.NewFlagSet()
+	outputFileName := flagSet.String("o", "", "Specify the output file name (defaults to the spec's name with a .prx extension).")
+	compress := flagSet.Bool("compress", false, "Compress the items inside the pre (items that don't get smaller are stored as-is).")
+	showHexDump := flagSet.Bool("showHexDump", false, "Display the pre bytes in hex format.")
+	compilationFlags := AddCompilationFlags(flagSet, project)
+	positionalArgs, err := command.ParseFlags(flagSet, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	if err := compilationFlags.Validate(); err != nil {
+		return err
+	}
+
+	if len(positionalArgs) == 0 {
+		if project == nil || len(project.Pres) == 0 {
+			return UsageError{fmt.Sprintf("ERROR - Specify a pre spec, or list pres in %s (see '%s -h')", projectFileName, command.FullName())}
+		}
+		if *outputFileName != "" {
+			return UsageError{"ERROR - -o only works with a pre spec, the project's pres have their own outputs"}
+		}
+		return BuildProjectPres(project, compilationFlags, flagWasGiven(flagSet, "targetGame"))
+	}
+	preSpecFile := positionalArgs[0]
+
+	if *outputFileName == "" {
+		*outputFileName = WithPrxExtension(preSpecFile)
+	}
+
+	fmt.Printf("\nGenerating pre file from spec '%s'...\n", preSpecFile)
+	preSpec, err := pre_generator.ParsePreSpec(preSpecFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New(fmt.Sprintf("ERROR - %s", err))
+		}
+		return err
+	}
+	err = pre_generator.MakePreFile(*outputFileName, preSpec, pre_generator.PreSettings{
+		Compress: *compress,
+		Compile:  compilationFlags.Compile,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  Created '%s'.\n\n", *outputFileName)
+
+	if *showHexDump {
+		pre, err := readFile(*outputFileName)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Hex dump:\n%s\n", hex.Dump(pre))
+	}
+
+	return nil
+}
+
+// Builds every pre listed in the project file. Pres are compiled for their own target game unless one was given
+// with -targetGame.
+func BuildProjectPres(project *Project, compilationFlags CompilationFlags, targetGameWasGiven bool) error {
+	for _, pre := range project.Pres {
+		preSpecFile := project.Resolve(pre.Spec)
+		outputFileName := project.Resolve(pre.Output)
+		if outputFileName == "" {
+			outputFileName = WithPrxExtension(preSpecFile)
+		}
+		preCompilationFlags := compilationFlags
+		if pre.TargetGame != "" && !targetGameWasGiven {
+			preCompilationFlags = compilationFlags.WithTargetGame(pre.TargetGame)
+		}
+
+		fmt.Printf("\nGenerating pre file from spec '%s'...\n", preSpecFile)
+		preSpec, err := pre_generator.ParsePreSpec(preSpecFile)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return errors.New(fmt.Sprintf("ERROR - %s", err))
+			}
+			return err
+		}
+		err = pre_generator.MakePreFile(outputFileName, preSpec, pre_generator.PreSettings{
+			Compress: pre.Compress,
+			Compile:  preCompilationFlags.Compile,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  Created '%s'.\n", outputFileName)
+	}
+	fmt.Println()
+	return nil
+}
+
+func RunPreList(command Command, args []string) error {
+	flagSet := command.NewFlagSet()
+	positionalArgs, err := command.ParseFlags(flagSet, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	pre, err := readPreFile(positionalArgs[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-10s %-10s %-10s %-10s %s\n", "Offset", "Size", "Deflated", "Checksum", "Path")
+	for _, item := range pre.Items {
+		deflatedSize := "-"
+		if item.IsCompressed() {
+			deflatedSize = fmt.Sprint(item.DeflatedSize)
+		}
+		checksumNote := ""
+		if !item.HasValidChecksum() {
+			checksumNote = "  (checksum doesn't match path)"
+		}
+		fmt.Printf("0x%08x %-10d %-10s 0x%08x %s%s\n", item.Offset, item.InflatedSize, deflatedSize, item.PathInsidePreChecksum, item.PathInsidePre, checksumNote)
+	}
+	fmt.Printf("\n%d item(s), %d bytes.\n", len(pre.Items), pre.Size)
+	return nil
+}
+
+func RunPreExtract(command Command, args []string) error {
+	flagSet := command.NewFlagSet()
+	outputDirectory := flagSet.String("o", "", "Specify the output directory (defaults to the pre's name without extension).")
+	positionalArgs, err := command.ParseFlags(flagSet, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	preFile := positionalArgs[0]
+	if *outputDirectory == "" {
+		*outputDirectory = withoutExtension(preFile)
+	}
+
+	pre, err := readPreFile(preFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nExtracting %d item(s) from '%s'...\n", len(pre.Items), preFile)
+	warnings, err := pre_generator.ExtractPre(pre, *outputDirectory)
+	for _, warning := range warnings {
+		fmt.Printf("  WARNING - %s\n", warning)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  Extracted to '%s'.\n\n", *outputDirectory)
+	return nil
+}
+
+func RunPreRepack(command Command, args []string) error {
+	flagSet := command.NewFlagSet()
+	outputFileName := flagSet.String("o", "", "Specify the output file name (can be the same as the input).")
+	compress := flagSet.Bool("compress", false, "Compress added items. Replaced items are compressed if the originals were.")
+	positionalArgs, err := command.ParseFlags(flagSet, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	if *outputFileName == "" {
+		return UsageError{fmt.Sprintf("ERROR - Specify the output file with -o, e.g. '%s in.prx overrides -o out.prx'", command.FullName())}
+	}
+	preFile, overridesDirectory := positionalArgs[0], positionalArgs[1]
+
+	fmt.Printf("\nRepacking '%s' with the files in '%s'...\n", preFile, overridesDirectory)
+	result, err := pre_generator.RepackPreFile(preFile, overridesDirectory, *outputFileName, pre_generator.PreSettings{
+		Compress: *compress,
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range result.Replaced {
+		fmt.Printf("  Replaced '%s'.\n", path)
+	}
+	for _, path := range result.Added {
+		fmt.Printf("  Added '%s'.\n", path)
+	}
+	fmt.Printf("  Created '%s'.\n\n", *outputFileName)
+	return nil
+}
+
+func readPreFile(path string) (pre_generator.Pre, error) {
+	preBytes, err := readFile(path)
+	if err != nil {
+		return pre_generator.Pre{}, err
+	}
+	pre, err := pre_generator.ReadPre(preBytes)
+	if err != nil {
+		return pre, errors.New(fmt.Sprintf("ERROR - %s: %s", path, err))
+	}
+	return pre, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byxor/NeverScript/compiler"
+	"github.com/byxor/NeverScript/decompiler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The project file is found by looking in the working directory, then in each of its parents.
+const projectFileName = "neverscript.json"
+
+// Settings shared by every command, so they don't need to be repeated as flags. Flags still take priority.
+// Paths are relative to the directory the project file is in.
+type Project struct {
+	Path string `json:"-"`
+
+	Sources              []string     `json:"sources"` // Files, directories or globs, used by 'ns build' and 'ns watch'
+	Output               string       `json:"output"`
+	TargetGames          []string     `json:"targetGames"` // The first is used by commands that only compile for one game
+	RemoveChecksums      bool         `json:"removeChecksums"`
+	LineNumbers          *bool        `json:"lineNumbers"` // Defaults to the target game's setting
+	PreserveLineNumbers  bool         `json:"preserveLineNumbers"`
+	Pres                 []ProjectPre `json:"pres"`
+	ChecksumDictionaries []string     `json:"checksumDictionaries"`
+}
+
+type ProjectPre struct {
+	Spec       string `json:"spec"`
+	Output     string `json:"output"`               // Defaults to the spec's name with a .prx extension
+	Compress   bool   `json:"compress"`
+	TargetGame string `json:"targetGame,omitempty"` // Defaults to the project's first target game
+}
+
+// Finds and reads the project file for the working directory. Without one, the project is nil.
+func FindProject() (*Project, error) {
+	directory, err := os.Getwd()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+	for {
+		path := filepath.Join(directory, projectFileName)
+		if _, err := os.Stat(path); err == nil {
+			return ReadProject(path)
+		}
+		parentDirectory := filepath.Dir(directory)
+		if parentDirectory == directory {
+			return nil, nil
+		}
+		directory = parentDirectory
+	}
+}
+
+func ReadProject(path string) (*Project, error) {
+	projectBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("ERROR - %s", err))
+	}
+
+	project := Project{Path: path}
+	decoder := json.NewDecoder(bytes.NewReader(projectBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&project); err != nil {
+		return nil, errors.New(fmt.Sprintf("ERROR - %s: %s", path, err))
+	}
+
+	for _, targetGame := range project.TargetGames {
+		if _, found := compiler.FindTargetGameProfile(targetGame); !found {
+			return nil, errors.New(fmt.Sprintf("ERROR - %s: Unknown target game '%s' (expected %s)", path, targetGame, strings.Join(compiler.TargetGameNames(), "/")))
+		}
+	}
+	for i, pre := range project.Pres {
+		if pre.Spec == "" {
+			return nil, errors.New(fmt.Sprintf("ERROR - %s: pre %d has no spec", path, i+1))
+		}
+		if _, found := compiler.FindTargetGameProfile(pre.TargetGame); pre.TargetGame != "" && !found {
+			return nil, errors.New(fmt.Sprintf("ERROR - %s: Unknown target game '%s' (expected %s)", path, pre.TargetGame, strings.Join(compiler.TargetGameNames(), "/")))
+		}
+	}
+
+	return &project, nil
+}
+
+// Makes a path from the project file relative to the working directory (or absolute).
+func (project *Project) Resolve(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	resolvedPath := filepath.Join(filepath.Dir(project.Path), path)
+	if workingDirectory, err := os.Getwd(); err == nil {
+		if relativePath, err := filepath.Rel(workingDirectory, resolvedPath); err == nil {
+			return relativePath
+		}
+	}
+	return resolvedPath
+}
+
+func (project *Project) ResolvedSources() []string {
+	var sources []string
+	for _, source := range project.Sources {
+		sources = append(sources, project.Resolve(source))
+	}
+	return sources
+}
+
+// The target game used when a command only compiles for one, e.g. 'ns compile'.
+func (project *Project) TargetGame() string {
+	if project == nil || len(project.TargetGames) == 0 {
+		return "thug2"
+	}
+	return project.TargetGames[0]
+}
+
+// Reads the project's checksum dictionaries into one table. Without a project, there are no names.
+func (project *Project) ChecksumNames() (map[uint32]string, error) {
+	checksumNames := map[uint32]string{}
+	if project == nil {
+		return checksumNames, nil
+	}
+	for _, dictionaryPath := range project.ChecksumDictionaries {
+		dictionary, err := decompiler.ReadChecksumDictionary(project.Resolve(dictionaryPath))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("ERROR - Couldn't read checksum dictionary: %s", err))
+		}
+		for checksum, name := range dictionary {
+			if _, found := checksumNames[checksum]; !found {
+				checksumNames[checksum] = name
+			}
+		}
+	}
+	return checksumNames, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"github.com/byxor/NeverScript/compiler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Changes the working directory for the length of the test.
+func chdirForTest(t *testing.T, directory string) {
+	t.Helper()
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(directory); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(workingDirectory) })
+}
+
+func writeProjectForTest(t *testing.T, directory string, contents string) string {
+	t.Helper()
+	path := filepath.Join(directory, projectFileName)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFindProjectFromSubdirectory(t *testing.T) {
+	directory, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	subdirectory := filepath.Join(directory, "scripts", "levels")
+	if err := os.MkdirAll(subdirectory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := writeProjectForTest(t, directory, `{"sources": ["scripts"], "output": "build", "targetGames": ["thps4", "thug2"]}`)
+
+	chdirForTest(t, subdirectory)
+	project, err := FindProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project == nil {
+		t.Fatal("Expected to find the project in a parent directory")
+	}
+	if project.Path != path {
+		t.Errorf("Expected the project at '%s', got '%s'", path, project.Path)
+	}
+	if project.TargetGame() != "thps4" {
+		t.Errorf("Expected the first target game, got '%s'", project.TargetGame())
+	}
+
+	// Paths are relative to the project file, not the working directory
+	if sources := project.ResolvedSources(); !reflect.DeepEqual(sources, []string{".."}) {
+		t.Errorf("Expected the sources to be '..', got %q", sources)
+	}
+	if output := project.Resolve(project.Output); output != filepath.Join("..", "..", "build") {
+		t.Errorf("Expected the output to be '../../build', got '%s'", output)
+	}
+}
+
+func TestFindProjectWithoutProjectFile(t *testing.T) {
+	chdirForTest(t, t.TempDir())
+
+	project, err := FindProject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project != nil {
+		t.Fatalf("Expected no project, got '%s'", project.Path)
+	}
+	if project.TargetGame() != "thug2" {
+		t.Errorf("Expected thug2 without a project, got '%s'", project.TargetGame())
+	}
+}
+
+func TestReadProjectErrors(t *testing.T) {
+	for _, test := range []struct {
+		contents      string
+		expectedError string
+	}{
+		{`{"sources": ["scripts"]`, "unexpected EOF"},
+		{`{"sources": "scripts"}`, "cannot unmarshal string"},
+		{`{"sources": ["scripts"],}`, "invalid character '}'"},
+		{`{"lint": {"unusedLocals": true}}`, `unknown field "lint"`},
+		{`{"targetGames": ["thaw"]}`, "Unknown target game 'thaw'"},
+		{`{"pres": [{"output": "qb.prx"}]}`, "pre 1 has no spec"},
+		{`{"pres": [{"spec": "qb.ps", "targetGame": "thaw"}]}`, "Unknown target game 'thaw'"},
+	} {
+		path := writeProjectForTest(t, t.TempDir(), test.contents)
+		project, err := ReadProject(path)
+		if err == nil {
+			t.Errorf("Expected an error for %s, got %+v", test.contents, *project)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "ERROR - "+path+": ") || !strings.Contains(err.Error(), test.expectedError) {
+			t.Errorf("Expected an error about %q in '%s' for %s, got '%s'", test.expectedError, path, test.contents, err)
+		}
+	}
+}
+
+func TestProjectLineNumbers(t *testing.T) {
+	originalProfiles := compiler.TargetGameProfiles
+	compiler.TargetGameProfiles = []compiler.TargetGameProfile{
+		{Name: "thps4", WriteLineNumbers: true},
+		{Name: "thug2"},
+	}
+	t.Cleanup(func() { compiler.TargetGameProfiles = originalProfiles })
+
+	for _, test := range []struct {
+		contents         string
+		args             []string
+		writeLineNumbers bool
+	}{
+		// Left to the target game
+		{`{"targetGames": ["thps4"]}`, nil, true},
+		{`{"targetGames": ["thug2"]}`, nil, false},
+
+		// The project overrides the target game, and the flag overrides the project
+		{`{"targetGames": ["thps4"], "lineNumbers": false}`, nil, false},
+		{`{"targetGames": ["thug2"], "lineNumbers": true}`, nil, true},
+		{`{"targetGames": ["thug2"], "lineNumbers": true}`, []string{"-lineNumbers=false"}, false},
+		{`{"targetGames": ["thps4"], "lineNumbers": false}`, []string{"-lineNumbers"}, true},
+	} {
+		project, err := ReadProject(writeProjectForTest(t, t.TempDir(), test.contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		flagSet := compileCommand.NewFlagSet()
+		flags := AddCompilationFlags(flagSet, project)
+		if err := flagSet.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		var bytecodeCompiler compiler.BytecodeCompiler
+		if err := flags.ApplyTo(&bytecodeCompiler); err != nil {
+			t.Fatal(err)
+		}
+		if bytecodeCompiler.WriteLineNumbers != test.writeLineNumbers {
+			t.Errorf("Expected WriteLineNumbers to be %t for %s with %q", test.writeLineNumbers, test.contents, test.args)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"github.com/byxor/NeverScript/pre_generator"
+	"os"
+	"runtime"
+	"time"
+)
+
+var watchCommand = Command{
+	Name:        "watch",
+	Arguments:   "[flags] [file.ns|directory|glob]...",
+	Description: "Compile NeverScript code (by default, the project's sources), then keep compiling files whenever they're saved. Stop with Ctrl+C.",
+	Run:         RunWatch,
+}
+
+// What a file looked like the last time it was checked. Files are compiled again when this changes.
+type watchedFileState struct {
+	ModificationTime time.Time
+	Size             int64
+}
+
+func RunWatch(command Command, args []string) error {
+	project, err := FindProject()
+	if err != nil {
+		return err
+	}
+	flagSet := command.NewFlagSet()
+	outputDirectory := flagSet.String("o", "", "Specify the output directory. The tree structure of the input is mirrored inside it (defaults to the project's output, or next to each input).")
+	repackFile := flagSet.String("repack", "", "Repack this pre file with the output directory (see 'ns pre repack') after every change. Requires -o.")
+	interval := flagSet.Duration("interval", 500*time.Millisecond, "Specify how often to check for changes.")
+	compilationFlags := AddCompilationFlags(flagSet, project)
+	jobs := flagSet.Int("jobs", runtime.NumCPU(), "Specify how many files to compile at once.")
+	positionalArgs, err := command.ParseFlags(flagSet, args, 0, -1)
+	if err != nil {
+		return err
+	}
+	if err := compilationFlags.Validate(); err != nil {
+		return err
+	}
+
+	if len(positionalArgs) == 0 {
+		if project == nil || len(project.Sources) == 0 {
+			return UsageError{fmt.Sprintf("ERROR - Specify what to watch, or list the sources in %s (see '%s -h')", projectFileName, command.FullName())}
+		}
+		positionalArgs = project.ResolvedSources()
+	}
+	if *outputDirectory == "" && project != nil {
+		*outputDirectory = project.Resolve(project.Output)
+	}
+	if *repackFile != "" && *outputDirectory == "" {
+		// Otherwise the source code would be packed too
+		return UsageError{fmt.Sprintf("ERROR - -repack needs an output directory, e.g. '%s scripts -o build -repack qb.prx'", command.FullName())}
+	}
+	if *interval <= 0 {
+		return UsageError{"ERROR - -interval must be positive"}
+	}
+
+	// Problems with the inputs are only fatal at the start, files can come and go while watching
+	if _, _, err := FindInputFiles(positionalArgs, ".ns"); err != nil {
+		return err
+	}
+
+	compile := func(input InputFile) BatchResult {
+		result := BatchResult{Input: input, OutputPath: input.OutputPath(*outputDirectory, ".qb")}
+		bytecodeCompiler, err := CompileNsFile(input.Path, compilationFlags)
+		if err == nil {
+			err = writeOutputFile(result.OutputPath, bytecodeCompiler.Bytes)
+			result.IncludedFilePaths = bytecodeCompiler.IncludedFilePaths
+		}
+		result.Err = err
+		return result
+	}
+
+	states := map[string]watchedFileState{}
+	includedFilePaths := map[string][]string{} // What each input included the last time it compiled
+	failedInputs := map[string]bool{}
+	isFirstCheck := true
+	for {
+		inputs, _, err := FindInputFiles(positionalArgs, ".ns")
+		if err != nil {
+			fmt.Printf("\n%s\n", err)
+		}
+
+		// Inputs are compiled again when they or the files they include change. A file that's gone counts as changed.
+		newStates := map[string]watchedFileState{}
+		hasChanged := func(path string) bool {
+			state, checked := newStates[path]
+			if !checked {
+				if fileInfo, err := os.Stat(path); err == nil {
+					state = watchedFileState{fileInfo.ModTime(), fileInfo.Size()}
+				}
+				newStates[path] = state
+			}
+			oldState, found := states[path]
+			return !found || oldState != state
+		}
+
+		var changedInputs []InputFile
+		for _, input := range inputs {
+			inputHasChanged := hasChanged(input.Path)
+			for _, includedFilePath := range includedFilePaths[input.Path] {
+				if hasChanged(includedFilePath) {
+					inputHasChanged = true
+				}
+			}
+			if inputHasChanged {
+				changedInputs = append(changedInputs, input)
+			}
+		}
+		states = newStates
+
+		// What made a file fail might have been fixed in a file it includes, so failures are retried after any change
+		if len(changedInputs) > 0 && len(failedInputs) > 0 {
+			for _, input := range inputs {
+				if failedInputs[input.Path] && !containsInput(changedInputs, input) {
+					changedInputs = append(changedInputs, input)
+				}
+			}
+		}
+
+		if len(changedInputs) > 0 {
+			if isFirstCheck {
+				fmt.Printf("\nCompiling %d file(s)...\n", len(changedInputs))
+			} else {
+				fmt.Printf("\n[%s] Compiling %d changed file(s)...\n", time.Now().Format("15:04:05"), len(changedInputs))
+			}
+			results := RunBatch(changedInputs, *jobs, compile)
+			for _, result := range results {
+				failedInputs[result.Input.Path] = result.Err != nil
+				if result.Err == nil {
+					includedFilePaths[result.Input.Path] = result.IncludedFilePaths
+					for _, includedFilePath := range result.IncludedFilePaths {
+						if _, found := states[includedFilePath]; !found {
+							hasChanged(includedFilePath)
+							states[includedFilePath] = newStates[includedFilePath]
+						}
+					}
+					if !isFirstCheck {
+						fmt.Printf("  Created '%s'.\n", result.OutputPath)
+					}
+				}
+			}
+			if err := ReportBatch(results, "Compiled"); err != nil {
+				fmt.Printf("\n%s\n", err)
+			}
+
+			if *repackFile != "" {
+				repackAfterWatch(*repackFile, *outputDirectory)
+			}
+		}
+
+		if isFirstCheck {
+			fmt.Printf("\nWatching for changes...\n")
+			isFirstCheck = false
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func containsInput(inputs []InputFile, input InputFile) bool {
+	for _, otherInput := range inputs {
+		if otherInput.Path == input.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// Failing to repack (e.g. because the game has the file open) shouldn't stop the watch.
+func repackAfterWatch(preFile string, outputDirectory string) {
+	result, err := pre_generator.RepackPreFile(preFile, outputDirectory, preFile, pre_generator.PreSettings{})
+	if err != nil {
+		fmt.Printf("\n%s\n", err)
+		return
+	}
+	fmt.Printf("\n  Repacked '%s' (%d replaced, %d added).\n", preFile, len(result.Replaced), len(result.Added))
+}
@@ -131,6 +131,8 @@ func (astData AstData_Script) astData() {}
 
 type AstData_WhileLoop struct {
 	BodyNodes    []AstNode
+	CountNode    AstNode // Only used when HasCount is true, otherwise the loop is infinite
+	HasCount     bool
 }
 func (astData AstData_WhileLoop) astData() {}
 
@@ -140,6 +142,13 @@ type AstData_IfStatement struct {
 }
 func (astData AstData_IfStatement) astData() {}
 
+type AstData_NewLine struct {
+	LineNumber            int // line of source code that the new-line ends
+	OriginalLineNumber    int // from a `// line N` annotation left by the decompiler
+	HasOriginalLineNumber bool
+}
+func (astData AstData_NewLine) astData() {}
+
 type AstData_Comment struct {
 	CommentToken Token
 }
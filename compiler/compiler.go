@@ -2,44 +2,43 @@ package compiler
 
 import (
 	"io/ioutil"
-	"log"
 	"path/filepath"
-	"strings"
 )
 
 func Compile(nsFilePath, qbFilePath string, lexer *Lexer, parser *Parser, bytecodeCompiler *BytecodeCompiler) Error {
-	{ // read source code into memory & store it in lexer
-		bytes, err := ioutil.ReadFile(nsFilePath)
-		if err != nil {
-			log.Fatal(err)
-		}
-		lexer.SourceCode = string(bytes)
-
-		// Remove weird windows line-endings
-		lexer.SourceCode = strings.Replace(lexer.SourceCode, "\r", "", -1)
-
-		lexer.SourceCodeSize = len(lexer.SourceCode)
+	if err := CompileToBytes(nsFilePath, lexer, parser, bytecodeCompiler); err != nil {
+		return err
 	}
 
-	err := LexSourceCode(lexer)
-	if err != nil { return err }
+	ioutil.WriteFile(qbFilePath, bytecodeCompiler.Bytes, 0644)
+
+	return nil
+}
 
-	parser.Tokens = lexer.Tokens
-	BuildAbstractSyntaxTree(parser)
-	if !parser.Result.GotResult {
-		log.Fatal(parser.Result.Reason)
-	} else if parser.Result.Error != nil {
+// Like Compile, but leaves the bytecode in bytecodeCompiler.Bytes instead of writing it to disk.
+func CompileToBytes(nsFilePath string, lexer *Lexer, parser *Parser, bytecodeCompiler *BytecodeCompiler) Error {
+	sourceCode, err := ioutil.ReadFile(nsFilePath)
+	if err != nil {
 		return CompilationError{
 			baseFilePath: filepath.Base(nsFilePath),
-			message:      parser.Result.Error.Error(),
-			lineNumber:   parser.Result.LineNumber,
+			message:      err.Error(),
 		}
 	}
 
-	bytecodeCompiler.RootAstNode = parser.Result.Node
-	GenerateBytecode(bytecodeCompiler)
+	includer := newIncluder(bytecodeCompiler.TargetGame)
+	rootNodes, compilationError := includer.parseFile(nsFilePath, sourceCode, lexer, parser)
+	if compilationError != nil {
+		return compilationError
+	}
 
-	ioutil.WriteFile(qbFilePath, bytecodeCompiler.Bytes, 0644)
+	bytecodeCompiler.RootAstNode = AstNode{
+		Kind: AstKind_Root,
+		Data: AstData_Root{
+			BodyNodes: rootNodes,
+		},
+	}
+	bytecodeCompiler.IncludedFilePaths = includer.filesIncluded
+	GenerateBytecode(bytecodeCompiler)
 
 	return nil
 }
@@ -0,0 +1,166 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Code can be compiled for some target games only, e.g. to call scripts that only exist in one of them:
+//
+//	#if thug2
+//	    SomeThug2Script
+//	#elif thug1 or thps4
+//	    SomeOtherScript
+//	#else
+//	    FallbackScript
+//	#endif
+//
+// Each directive goes on a line of its own. A condition is one or more game names separated by `or`, and a name can
+// be negated with `!` (e.g. `#if !thps3`). Code in branches that aren't taken is removed before it's parsed, so it
+// doesn't need to compile for the other games. Includes inside a branch are only included when it's taken.
+
+type conditionalBranch struct {
+	directive     Token // The #if that started it, for reporting a missing #endif
+	isTaken       bool  // Whether the code in the current branch is compiled
+	wasTaken      bool  // Whether any branch so far was taken
+	parentIsTaken bool
+	sawElse       bool
+}
+
+// Removes the code in branches that aren't taken for the target game, leaving the new-lines behind so line numbers
+// stay the same.
+func evaluateConditionalDirectives(tokens []Token, targetGame string, baseFilePath string) ([]Token, Error) {
+	var remainingTokens []Token
+	var branches []conditionalBranch
+
+	newError := func(token Token, message string, args ...interface{}) Error {
+		return CompilationError{
+			baseFilePath: baseFilePath,
+			message:      fmt.Sprintf(message, args...),
+			lineNumber:   token.LineNumber,
+		}
+	}
+
+	isTaken := func() bool {
+		return len(branches) == 0 || branches[len(branches)-1].isTaken
+	}
+
+	isStartOfLine := true
+	for index := 0; index < len(tokens); index++ {
+		token := tokens[index]
+
+		if token.Kind != TokenKind_Directive {
+			if token.Kind == TokenKind_NewLine || isTaken() {
+				remainingTokens = append(remainingTokens, token)
+			}
+			isStartOfLine = token.Kind == TokenKind_NewLine || (isStartOfLine && token.Kind == TokenKind_MultiLineComment)
+			continue
+		}
+
+		if !isStartOfLine {
+			return nil, newError(token, "'%s' must be at the start of a line", token.Data)
+		}
+
+		// The rest of the line is the condition
+		var conditionTokens []Token
+		for index+1 < len(tokens) && tokens[index+1].Kind != TokenKind_NewLine {
+			index++
+			if tokens[index].Kind != TokenKind_SingleLineComment && tokens[index].Kind != TokenKind_MultiLineComment {
+				conditionTokens = append(conditionTokens, tokens[index])
+			}
+		}
+
+		switch token.Data {
+		case "#if":
+			condition, err := evaluateCondition(conditionTokens, targetGame)
+			if err != "" {
+				return nil, newError(token, "%s in '#if'", err)
+			}
+			parentIsTaken := isTaken()
+			branches = append(branches, conditionalBranch{
+				directive:     token,
+				isTaken:       parentIsTaken && condition,
+				wasTaken:      condition,
+				parentIsTaken: parentIsTaken,
+			})
+
+		case "#elif":
+			if len(branches) == 0 {
+				return nil, newError(token, "'#elif' without an '#if'")
+			}
+			branch := &branches[len(branches)-1]
+			if branch.sawElse {
+				return nil, newError(token, "'#elif' after '#else'")
+			}
+			condition, err := evaluateCondition(conditionTokens, targetGame)
+			if err != "" {
+				return nil, newError(token, "%s in '#elif'", err)
+			}
+			branch.isTaken = branch.parentIsTaken && !branch.wasTaken && condition
+			branch.wasTaken = branch.wasTaken || condition
+
+		case "#else":
+			if len(branches) == 0 {
+				return nil, newError(token, "'#else' without an '#if'")
+			}
+			if len(conditionTokens) > 0 {
+				return nil, newError(token, "'#else' doesn't take a condition (did you mean '#elif'?)")
+			}
+			branch := &branches[len(branches)-1]
+			if branch.sawElse {
+				return nil, newError(token, "'#if' already has an '#else'")
+			}
+			branch.sawElse = true
+			branch.isTaken = branch.parentIsTaken && !branch.wasTaken
+			branch.wasTaken = true
+
+		case "#endif":
+			if len(branches) == 0 {
+				return nil, newError(token, "'#endif' without an '#if'")
+			}
+			if len(conditionTokens) > 0 {
+				return nil, newError(token, "'#endif' doesn't take a condition")
+			}
+			branches = branches[:len(branches)-1]
+		}
+	}
+
+	if len(branches) > 0 {
+		return nil, newError(branches[len(branches)-1].directive, "'#if' is missing an '#endif'")
+	}
+
+	return remainingTokens, nil
+}
+
+// Evaluates e.g. `thug1 or !thps4`, returning a description of the problem if it can't.
+func evaluateCondition(tokens []Token, targetGame string) (bool, string) {
+	if len(tokens) == 0 {
+		return false, fmt.Sprintf("Expected a game name (%s)", strings.Join(TargetGameNames(), "/"))
+	}
+
+	result := false
+	for index := 0; index < len(tokens); index++ {
+		if index > 0 {
+			if tokens[index].Kind != TokenKind_Or || index+1 >= len(tokens) {
+				return false, fmt.Sprintf("Expected 'or' and another game name, got '%s'", tokens[index].Data)
+			}
+			index++
+		}
+
+		isNegated := false
+		if tokens[index].Kind == TokenKind_Bang {
+			isNegated = true
+			index++
+			if index >= len(tokens) {
+				return false, "Expected a game name after '!'"
+			}
+		}
+
+		gameName := tokens[index].Data
+		if _, found := FindTargetGameProfile(gameName); tokens[index].Kind != TokenKind_Identifier || !found {
+			return false, fmt.Sprintf("Unknown game '%s' (expected %s)", gameName, strings.Join(TargetGameNames(), "/"))
+		}
+		result = result || (strings.EqualFold(gameName, targetGame) != isNegated)
+	}
+	return result, ""
+}
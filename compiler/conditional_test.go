@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Compiles both for the target game and checks they produce the same QB.
+func expectSameQbForGame(t *testing.T, targetGame string, sourceCode string, expectedSourceCode string) {
+	t.Helper()
+	qb, err := compileFilesForTest(t, targetGame, [2]string{"main.ns", sourceCode})
+	if err != nil {
+		t.Fatal(err.ToError())
+	}
+	expectedQb, err := compileFilesForTest(t, targetGame, [2]string{"main.ns", expectedSourceCode})
+	if err != nil {
+		t.Fatal(err.ToError())
+	}
+	if !bytes.Equal(qb, expectedQb) {
+		t.Fatalf("Compiled differently for %s:\n%s\n% x\n\nExpected the same as:\n%s\n% x", targetGame, sourceCode, qb, expectedSourceCode, expectedQb)
+	}
+}
+
+func TestConditionalSelection(t *testing.T) {
+	sourceCode := "script Foo {\n#if thug2\n    A\n#elif thug1 or thps4\n    B\n#else\n    C\n#endif\n}\n"
+	expectedSourceCode := map[string]string{
+		"thps3": "script Foo {\n\n\n\n\n\n    C\n\n}\n",
+		"thps4": "script Foo {\n\n\n\n    B\n\n\n\n}\n",
+		"thug1": "script Foo {\n\n\n\n    B\n\n\n\n}\n",
+		"thug2": "script Foo {\n\n    A\n\n\n\n\n\n}\n",
+	}
+	for targetGame, expected := range expectedSourceCode {
+		expectSameQbForGame(t, targetGame, sourceCode, expected)
+	}
+
+	// Negation, and game names are case-insensitive
+	sourceCode = "#if !THPS3\nx = 1\n#endif\n"
+	expectSameQbForGame(t, "thps3", sourceCode, "\n\n\n")
+	expectSameQbForGame(t, "thps4", sourceCode, "\nx = 1\n\n")
+}
+
+func TestNestedConditionals(t *testing.T) {
+	sourceCode := "#if thug1 or thug2\n#if thug2\nx = 2\n#else\nx = 1\n#endif\n#else\n#if thps4\nx = 4\n#endif\n#endif\n"
+	expectedSourceCode := map[string]string{
+		"thps3": "\n\n\n\n\n\n\n\n\n\n\n",
+		"thps4": "\n\n\n\n\n\n\n\nx = 4\n\n\n",
+		"thug1": "\n\n\n\nx = 1\n\n\n\n\n\n\n",
+		"thug2": "\n\nx = 2\n\n\n\n\n\n\n\n\n",
+	}
+	for targetGame, expected := range expectedSourceCode {
+		expectSameQbForGame(t, targetGame, sourceCode, expected)
+	}
+}
+
+func TestConditionalErrors(t *testing.T) {
+	for _, testCase := range []struct {
+		sourceCode    string
+		expectedError string
+	}{
+		{"x = 1\n#elif thug2\n", "ERROR main.ns(line 2) - '#elif' without an '#if'"},
+		{"#else\n", "ERROR main.ns(line 1) - '#else' without an '#if'"},
+		{"#endif\n", "ERROR main.ns(line 1) - '#endif' without an '#if'"},
+		{"#if thug2\nx = 1\n", "ERROR main.ns(line 1) - '#if' is missing an '#endif'"},
+		{"#if thug2\n#if thug1\n#endif\n", "ERROR main.ns(line 1) - '#if' is missing an '#endif'"},
+		{"#if thug2\n#else\n#elif thug1\n#endif\n", "ERROR main.ns(line 3) - '#elif' after '#else'"},
+		{"#if thug2\n#else\n#else\n#endif\n", "ERROR main.ns(line 3) - '#if' already has an '#else'"},
+		{"#if thug2\n#else thug1\n#endif\n", "ERROR main.ns(line 2) - '#else' doesn't take a condition (did you mean '#elif'?)"},
+		{"#if thug2\n#endif thug2\n", "ERROR main.ns(line 2) - '#endif' doesn't take a condition"},
+		{"x = 1 #if thug2\n#endif\n", "ERROR main.ns(line 1) - '#if' must be at the start of a line"},
+		{"#if thug3\n#endif\n", "ERROR main.ns(line 1) - Unknown game 'thug3' (expected thps3/thps4/thug1/thug2) in '#if'"},
+		{"#if\n#endif\n", "ERROR main.ns(line 1) - Expected a game name (thps3/thps4/thug1/thug2) in '#if'"},
+		{"#if thug2 thug1\n#endif\n", "ERROR main.ns(line 1) - Expected 'or' and another game name, got 'thug1' in '#if'"},
+	} {
+		_, err := compileFilesForTest(t, "thug2", [2]string{"main.ns", testCase.sourceCode})
+		expectCompilationError(t, err, testCase.expectedError)
+	}
+}
+
+func TestConditionalInclude(t *testing.T) {
+	files := [][2]string{
+		{"main.ns", "#if thug2\ninclude \"thug2.ns\"\n#endif\n"},
+		{"thug2.ns", "x = 2\n"},
+	}
+	qb, err := compileFilesForTest(t, "thug2", files...)
+	if err != nil {
+		t.Fatal(err.ToError())
+	}
+	if !bytes.Contains(qb, []byte{0x17, 0x02, 0x00, 0x00, 0x00}) {
+		t.Fatalf("Expected thug2.ns to be included:\n% x", qb)
+	}
+
+	// The file isn't included for other games, so it doesn't even need to exist
+	if _, err := compileFilesForTest(t, "thps4", files[0]); err != nil {
+		t.Fatal(err.ToError())
+	}
+}
@@ -0,0 +1,211 @@
+package compiler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Other files are included with `include "path/to/file.ns"` on a line of its own, outside of scripts and structs.
+// The path is relative to the file doing the including.
+//
+// Every file is compiled once, however many times it's included, and its globals come before the globals of the file
+// that included it. Each file is lexed and parsed on its own so errors point at the right file and line.
+
+type includer struct {
+	filesInProgress []string // The chain of includes that led to the file being compiled, for reporting cycles
+	filesCompiled   map[string]bool
+	filesIncluded   []string
+	globals         map[string]globalDefinition
+	targetGame      string // For evaluating #if directives
+}
+
+type globalDefinition struct {
+	filePath   string
+	lineNumber int
+}
+
+type includeDirective struct {
+	path       string
+	lineNumber int
+}
+
+func newIncluder(targetGame string) includer {
+	return includer{
+		filesCompiled: make(map[string]bool),
+		globals:       make(map[string]globalDefinition),
+		targetGame:    targetGame,
+	}
+}
+
+// Lexes and parses a file and everything it includes, returning the nodes for the root of the program.
+func (includer *includer) parseFile(nsFilePath string, sourceCode []byte, lexer *Lexer, parser *Parser) ([]AstNode, Error) {
+	baseFilePath := filepath.Base(nsFilePath)
+	absolutePath, _ := filepath.Abs(nsFilePath)
+	includer.filesInProgress = append(includer.filesInProgress, absolutePath)
+	defer func() {
+		includer.filesInProgress = includer.filesInProgress[:len(includer.filesInProgress)-1]
+		includer.filesCompiled[absolutePath] = true
+	}()
+
+	lexer.SourceCode = string(sourceCode)
+
+	// Remove weird windows line-endings
+	lexer.SourceCode = strings.Replace(lexer.SourceCode, "\r", "", -1)
+
+	lexer.SourceCodeSize = len(lexer.SourceCode)
+	lexer.BaseFilePath = baseFilePath
+
+	err := LexSourceCode(lexer)
+	if err != nil { return nil, err }
+
+	tokens, err := evaluateConditionalDirectives(lexer.Tokens, includer.targetGame, baseFilePath)
+	if err != nil { return nil, err }
+
+	tokens, includeDirectives := findIncludeDirectives(tokens)
+
+	var rootNodes []AstNode
+	for _, directive := range includeDirectives {
+		includedFilePath := filepath.FromSlash(strings.Replace(directive.path, "\\", "/", -1))
+		if !filepath.IsAbs(includedFilePath) {
+			includedFilePath = filepath.Join(filepath.Dir(nsFilePath), includedFilePath)
+		}
+		absoluteIncludedFilePath, _ := filepath.Abs(includedFilePath)
+
+		for i, fileInProgress := range includer.filesInProgress {
+			if fileInProgress == absoluteIncludedFilePath {
+				var cycle []string
+				for _, fileInCycle := range includer.filesInProgress[i:] {
+					cycle = append(cycle, filepath.Base(fileInCycle))
+				}
+				cycle = append(cycle, filepath.Base(absoluteIncludedFilePath))
+				return nil, CompilationError{
+					baseFilePath: baseFilePath,
+					message:      fmt.Sprintf("Include cycle: %s", strings.Join(cycle, " -> ")),
+					lineNumber:   directive.lineNumber,
+				}
+			}
+		}
+		if includer.filesCompiled[absoluteIncludedFilePath] {
+			continue
+		}
+
+		includedSourceCode, readErr := ioutil.ReadFile(includedFilePath)
+		if readErr != nil {
+			return nil, CompilationError{
+				baseFilePath: baseFilePath,
+				message:      fmt.Sprintf("Can't include '%s' - %s", directive.path, readErr.Error()),
+				lineNumber:   directive.lineNumber,
+			}
+		}
+
+		includer.filesIncluded = append(includer.filesIncluded, includedFilePath)
+		var includedLexer Lexer
+		var includedParser Parser
+		includedNodes, err := includer.parseFile(includedFilePath, includedSourceCode, &includedLexer, &includedParser)
+		if err != nil {
+			return nil, err
+		}
+		rootNodes = append(rootNodes, includedNodes...)
+	}
+
+	parser.Tokens = tokens
+	BuildAbstractSyntaxTree(parser)
+	if !parser.Result.GotResult {
+		return nil, CompilationError{
+			baseFilePath: baseFilePath,
+			message:      parser.Result.Reason,
+		}
+	} else if parser.Result.Error != nil {
+		return nil, CompilationError{
+			baseFilePath: baseFilePath,
+			message:      parser.Result.Error.Error(),
+			lineNumber:   parser.Result.LineNumber,
+		}
+	}
+
+	ownNodes := parser.Result.Node.Data.(AstData_Root).BodyNodes
+	for _, node := range ownNodes {
+		name, lineNumber, isGlobal := globalName(node)
+		if !isGlobal {
+			continue
+		}
+		key := strings.ToLower(name)
+		if existingDefinition, found := includer.globals[key]; found && existingDefinition.filePath != absolutePath {
+			return nil, CompilationError{
+				baseFilePath: baseFilePath,
+				message:      fmt.Sprintf("'%s' is already defined in %s (line %d)", name, filepath.Base(existingDefinition.filePath), existingDefinition.lineNumber),
+				lineNumber:   lineNumber,
+			}
+		}
+		includer.globals[key] = globalDefinition{absolutePath, lineNumber}
+	}
+
+	return append(rootNodes, ownNodes...), nil
+}
+
+// Takes the include directives out of the tokens, leaving the new-lines behind so line numbers stay the same.
+func findIncludeDirectives(tokens []Token) ([]Token, []includeDirective) {
+	var remainingTokens []Token
+	var includeDirectives []includeDirective
+
+	isEndOfLine := func(index int) bool {
+		if index >= len(tokens) {
+			return true
+		}
+		kind := tokens[index].Kind
+		return kind == TokenKind_NewLine || kind == TokenKind_SingleLineComment || kind == TokenKind_MultiLineComment
+	}
+
+	nestingDepth := 0
+	isStartOfLine := true
+	for index := 0; index < len(tokens); index++ {
+		token := tokens[index]
+		isInclude := nestingDepth == 0 &&
+			isStartOfLine &&
+			token.Kind == TokenKind_Identifier &&
+			token.Data == "include" &&
+			index+1 < len(tokens) &&
+			tokens[index+1].Kind == TokenKind_String &&
+			isEndOfLine(index+2)
+		if isInclude {
+			quotedPath := tokens[index+1].Data
+			includeDirectives = append(includeDirectives, includeDirective{
+				path:       quotedPath[1 : len(quotedPath)-1],
+				lineNumber: token.LineNumber,
+			})
+			index++
+			continue
+		}
+
+		switch token.Kind {
+		case TokenKind_LeftCurlyBrace, TokenKind_LeftSquareBracket, TokenKind_LeftParenthesis:
+			nestingDepth++
+		case TokenKind_RightCurlyBrace, TokenKind_RightSquareBracket, TokenKind_RightParenthesis:
+			nestingDepth--
+		}
+		isStartOfLine = token.Kind == TokenKind_NewLine || (isStartOfLine && token.Kind == TokenKind_MultiLineComment)
+		remainingTokens = append(remainingTokens, token)
+	}
+
+	return remainingTokens, includeDirectives
+}
+
+// Finds the name of a global defined by a node at the root of a file, e.g. `Foo` in `script Foo {}` or `x = 10`.
+func globalName(node AstNode) (string, int, bool) {
+	var nameNode AstNode
+	switch node.Kind {
+	case AstKind_Script:
+		nameNode = node.Data.(AstData_Script).NameNode
+	case AstKind_Assignment:
+		nameNode = node.Data.(AstData_Assignment).NameNode
+	default:
+		return "", 0, false
+	}
+	if nameNode.Kind != AstKind_Checksum {
+		return "", 0, false
+	}
+	checksumToken := nameNode.Data.(AstData_Checksum).ChecksumToken
+	return checksumToken.Data, checksumToken.LineNumber, true
+}
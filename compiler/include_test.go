@@ -0,0 +1,110 @@
+package compiler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes the files into a temporary directory, then compiles the first one.
+func compileFilesForTest(t *testing.T, targetGame string, files ...[2]string) ([]byte, Error) {
+	directory := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(directory, filepath.FromSlash(file[0]))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var lexer Lexer
+	var parser Parser
+	var bytecodeCompiler BytecodeCompiler
+	bytecodeCompiler.TargetGame = targetGame
+	err := CompileToBytes(filepath.Join(directory, filepath.FromSlash(files[0][0])), &lexer, &parser, &bytecodeCompiler)
+	return bytecodeCompiler.Bytes, err
+}
+
+func expectCompilationError(t *testing.T, err Error, expectedError string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("Expected '%s', but it compiled", expectedError)
+	}
+	if message := err.ToError().Error(); message != expectedError {
+		t.Fatalf("Expected '%s', got '%s'", expectedError, message)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	qb, err := compileFilesForTest(t, "thug2",
+		[2]string{"main.ns", "include \"lib/helpers.ns\"\ninclude \"lib/constants.ns\"\n\nscript Main {\n    Helper\n}\n"},
+		[2]string{"lib/helpers.ns", "include \"constants.ns\"\nscript Helper {\n    Foo\n}\n"},
+		[2]string{"lib/constants.ns", "speed = 10\n"},
+	)
+	if err != nil {
+		t.Fatal(err.ToError())
+	}
+	// Each file only once, with the globals of included files first
+	expectedQb := []byte{
+		0x01, 0x16, 0x09, 0x01, 0xd9, 0xf0, 0x07, 0x17, 0x0a, 0x00, 0x00, 0x00, 0x01, // constants.ns: speed = 10
+		0x01, 0x23, 0x16, 0x4f, 0x84, 0xc8, 0x78, 0x01, 0x16, 0xde, 0x9a, 0x8c, 0x73, 0x01, 0x24, 0x01, // helpers.ns: script Helper
+		0x01, 0x23, 0x16, 0x9b, 0x32, 0xd7, 0x40, 0x01, 0x16, 0x4f, 0x84, 0xc8, 0x78, 0x01, 0x24, 0x01, // main.ns: script Main
+	}
+	if !bytes.HasPrefix(qb, expectedQb) || qb[len(expectedQb)] != 0x2b {
+		t.Fatalf("Included files weren't compiled in order:\nExpected: % x\nGot:      % x", expectedQb, qb)
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	_, err := compileFilesForTest(t, "thug2",
+		[2]string{"a.ns", "include \"b.ns\"\n"},
+		[2]string{"b.ns", "x = 1\n\ninclude \"c.ns\"\n"},
+		[2]string{"c.ns", "include \"a.ns\"\n"},
+	)
+	expectCompilationError(t, err, "ERROR c.ns(line 1) - Include cycle: a.ns -> b.ns -> c.ns -> a.ns")
+
+	_, err = compileFilesForTest(t, "thug2",
+		[2]string{"a.ns", "include \"a.ns\"\n"},
+	)
+	expectCompilationError(t, err, "ERROR a.ns(line 1) - Include cycle: a.ns -> a.ns")
+}
+
+func TestIncludeMissingFile(t *testing.T) {
+	_, err := compileFilesForTest(t, "thug2",
+		[2]string{"main.ns", "x = 1\ninclude \"missing.ns\"\n"},
+	)
+	if err == nil {
+		t.Fatal("Expected an error for the missing file")
+	}
+	if message := err.ToError().Error(); !strings.HasPrefix(message, "ERROR main.ns(line 2) - Can't include 'missing.ns' - ") {
+		t.Fatalf("Unexpected error: %s", message)
+	}
+}
+
+func TestIncludeDuplicateGlobals(t *testing.T) {
+	_, err := compileFilesForTest(t, "thug2",
+		[2]string{"main.ns", "include \"other.ns\"\n\nscript Foo {\n}\n"},
+		[2]string{"other.ns", "x = 1\n\nscript foo {\n}\n"},
+	)
+	expectCompilationError(t, err, "ERROR main.ns(line 3) - 'Foo' is already defined in other.ns (line 3)")
+
+	_, err = compileFilesForTest(t, "thug2",
+		[2]string{"main.ns", "include \"other.ns\"\nx = 2\n"},
+		[2]string{"other.ns", "x = 1\n"},
+	)
+	expectCompilationError(t, err, "ERROR main.ns(line 2) - 'x' is already defined in other.ns (line 1)")
+
+	// Assigning the same global twice in one file is still allowed
+	_, err = compileFilesForTest(t, "thug2",
+		[2]string{"main.ns", "include \"other.ns\"\n"},
+		[2]string{"other.ns", "x = 1\nx = 2\n"},
+	)
+	if err != nil {
+		t.Fatal(err.ToError())
+	}
+}
@@ -88,6 +88,15 @@ func LexSourceCode(lexer *Lexer) Error { // do lexical analysis (build an array
 		return "", false
 	}
 
+	CanFindDirective := func() (string, bool) {
+		for _, directive := range []string{"#if", "#elif", "#else", "#endif"} {
+			if CanFindKeyword(directive, true) {
+				return directive, true
+			}
+		}
+		return "", false
+	}
+
 	CanFindRawChecksum := func() (string, bool) {
 		start := lexer.Index
 		end := start
@@ -314,6 +323,9 @@ func LexSourceCode(lexer *Lexer) Error { // do lexical analysis (build an array
 		} else if data, found := CanFindMultiLineComment(); found {
 			SaveToken(lexer, TokenKind_MultiLineComment, data)
 			lexer.Index += len(data)
+		} else if data, found := CanFindDirective(); found {
+			SaveToken(lexer, TokenKind_Directive, data)
+			lexer.Index += len(data)
 		} else if data, found := CanFindRawChecksum(); found {
 			SaveToken(lexer, TokenKind_RawChecksum, data)
 			lexer.Index += len(data)
@@ -404,6 +416,10 @@ func LexSourceCode(lexer *Lexer) Error { // do lexical analysis (build an array
 				} else if CanFindKeyword("while", true) {
 					SaveToken(lexer, TokenKind_While, "while")
 					lexer.Index += 5
+				} else if CanFindKeyword("loop", true) {
+					// The decompiler (and newcompiler) write loops as `loop { ... }`
+					SaveToken(lexer, TokenKind_While, "loop")
+					lexer.Index += 4
 				} else if CanFindKeyword("break", true) {
 					SaveToken(lexer, TokenKind_Break, "break")
 					lexer.Index += 5
@@ -421,6 +437,7 @@ func LexSourceCode(lexer *Lexer) Error { // do lexical analysis (build an array
 						return CompilationError{
 							message:      err.Error(),
 							lineNumber:   lexer.LineNumber,
+							baseFilePath: lexer.BaseFilePath,
 						}
 					}
 					if identifier[0] == '`' && identifier[len(identifier)-1] == '`' {
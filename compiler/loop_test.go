@@ -0,0 +1,81 @@
+package compiler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Compiles the source code and returns the first loop in its first script.
+func parseWhileLoopForTest(t *testing.T, sourceCode string) AstData_WhileLoop {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "main.ns")
+	if err := ioutil.WriteFile(path, []byte(sourceCode), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lexer Lexer
+	var parser Parser
+	var bytecodeCompiler BytecodeCompiler
+	if err := CompileToBytes(path, &lexer, &parser, &bytecodeCompiler); err != nil {
+		t.Fatal(err.ToError())
+	}
+	for _, rootNode := range bytecodeCompiler.RootAstNode.Data.(AstData_Root).BodyNodes {
+		if rootNode.Kind != AstKind_Script {
+			continue
+		}
+		for _, node := range rootNode.Data.(AstData_Script).BodyNodes {
+			if node.Kind == AstKind_WhileLoop {
+				return node.Data.(AstData_WhileLoop)
+			}
+		}
+	}
+	t.Fatalf("No loop in:\n%s", sourceCode)
+	return AstData_WhileLoop{}
+}
+
+func TestWhileLoopCounts(t *testing.T) {
+	for _, testCase := range []struct {
+		loop     string
+		hasCount bool
+	}{
+		{"while { Tock }", false},
+		{"while { Tock } 5", true},
+		{"while { Tock } -1", true},
+		{"while { Tock } <count>", true},
+		{"while { Tock } (<count> + 1)", true},
+		{"loop { Tock } 5", true},
+
+		// Anything else after the '}' is the next statement
+		{"while { Tock } Foo", false},
+		{"while { Tock } Foo 5", false},
+	} {
+		loop := parseWhileLoopForTest(t, "script Test {\n    "+testCase.loop+"\n}\n")
+		if loop.HasCount != testCase.hasCount {
+			t.Errorf("Expected HasCount to be %t for `%s`", testCase.hasCount, testCase.loop)
+		}
+	}
+
+	// On THUG2, only infinite loops get the bypasser
+	qb, err := compileFilesForTest(t, "thug2", [2]string{"main.ns", "script Test {\n    while { Tock } Foo\n}\n"})
+	if err != nil {
+		t.Fatal(err.ToError())
+	}
+	if !bytes.Contains(qb, []byte("__COMPILER__infinite_loop_bypasser")) {
+		t.Errorf("Expected `while { Tock } Foo` to be an infinite loop:\n% x", qb)
+	}
+}
+
+func TestLoopIsAKeyword(t *testing.T) {
+	for _, sourceCode := range []string{
+		"script Test {\n    loop\n}\n",
+		"script Test {\n    loop speed = 10\n}\n",
+	} {
+		_, err := compileFilesForTest(t, "thug2", [2]string{"main.ns", sourceCode})
+		expectCompilationError(t, err, "ERROR main.ns(line 2) - 'loop' must be followed by '{' (it's a keyword, so it can't be used as a name)")
+	}
+
+	_, err := compileFilesForTest(t, "thug2", [2]string{"main.ns", "script Test {\n    while\n}\n"})
+	expectCompilationError(t, err, "ERROR main.ns(line 2) - 'while' must be followed by '{' (it's a keyword, so it can't be used as a name)")
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,6 +19,15 @@ type BytecodeCompiler struct {
 	NextLoopBypasserId int
 	TargetGame         string
 	RemoveChecksums    bool
+
+	// Write new-lines with the line number of the source code (0x02 instead of 0x01).
+	WriteLineNumbers bool
+
+	// Write `// line N` annotations (left by the decompiler) back out as line-numbered new-lines.
+	PreserveLineNumbers bool
+
+	// Every file that was included while compiling, directly or not (set by CompileToBytes).
+	IncludedFilePaths []string
 }
 
 func GenerateBytecode(compiler *BytecodeCompiler) {
@@ -63,6 +73,7 @@ func GenerateBytecode(compiler *BytecodeCompiler) {
 	}
 
 	nameTable := make(map[string]uint32)
+	lineNumber := 0
 
 	var writeBytecodeForNode func(node AstNode)
 	var writeBytecodeForIf func(node AstNode)
@@ -82,7 +93,20 @@ func GenerateBytecode(compiler *BytecodeCompiler) {
 				writeBytecodeForNode(rootNode)
 			}
 		case AstKind_NewLine:
-			write(1)
+			newLineData, ok := node.Data.(AstData_NewLine)
+			if ok {
+				// new-lines made up by the compiler don't have one, so they reuse the last line number
+				lineNumber = newLineData.LineNumber
+			}
+			if ok && compiler.PreserveLineNumbers && newLineData.HasOriginalLineNumber {
+				write(2)
+				writeLittleUint32(uint32(newLineData.OriginalLineNumber))
+			} else if compiler.WriteLineNumbers {
+				write(2)
+				writeLittleUint32(uint32(lineNumber))
+			} else {
+				write(1)
+			}
 		case AstKind_Comma:
 			write(9)
 		case AstKind_Break:
@@ -261,7 +285,10 @@ func GenerateBytecode(compiler *BytecodeCompiler) {
 			}
 
 		case AstKind_WhileLoop:
-			if compiler.TargetGame == "thug2" {
+			loopData := node.Data.(AstData_WhileLoop)
+
+			// Counted loops always end, so they don't need to get past THUG2's infinite loop checks
+			if compiler.TargetGame == "thug2" && !loopData.HasCount {
 				compilerGeneratedChecksum := AstNode{
 					Kind: AstKind_Checksum,
 					Data: AstData_Checksum{
@@ -330,10 +357,13 @@ func GenerateBytecode(compiler *BytecodeCompiler) {
 				write(0x20)
 			}
 
-			for _, bodyNode := range node.Data.(AstData_WhileLoop).BodyNodes {
+			for _, bodyNode := range loopData.BodyNodes {
 				writeBytecodeForNode(bodyNode)
 			}
 			write(0x21)
+			if loopData.HasCount {
+				writeBytecodeForNode(loopData.CountNode)
+			}
 		case AstKind_Return:
 			data := node.Data.(AstData_UnaryExpression)
 
@@ -618,8 +648,14 @@ func GenerateBytecode(compiler *BytecodeCompiler) {
 	writeBytecodeForNode(compiler.RootAstNode)
 
 	if !compiler.RemoveChecksums {
-		for name, checksum := range nameTable {
-			writeNameTableEntry(checksum, name)
+		// Sorted so that the same code always compiles to the same bytes
+		names := make([]string, 0, len(nameTable))
+		for name := range nameTable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			writeNameTableEntry(nameTable[name], name)
 		}
 	}
 	write(0)
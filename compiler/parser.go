@@ -1023,21 +1023,46 @@ func BuildAbstractSyntaxTree(parser *Parser) {
 
 		bodyParseResult, bodyNodes := ParseBodyOfCode(index)
 		if bodyParseResult.GotResult {
+			if bodyParseResult.Error != nil {
+				return bodyParseResult
+			}
+			index += bodyParseResult.TokensConsumed
+
+			// A count after the '}' (on the same line) is the number of times to loop, e.g. `while { ... } 5`.
+			// Only ints, locals and parenthesised expressions are counts, so `while { ... } Foo` is still an
+			// infinite loop followed by a call to Foo.
+			loopData := AstData_WhileLoop{
+				BodyNodes: bodyNodes,
+			}
+			countTokensConsumed := 0
+			isInteger := GetKind(index) == TokenKind_Integer || (GetKind(index) == TokenKind_Minus && GetKind(index+1) == TokenKind_Integer)
+			if kind := GetKind(index); isInteger || kind == TokenKind_LeftAngleBracket || kind == TokenKind_LeftParenthesis {
+				if countParseResult := ParseExpression(index, false); countParseResult.GotResult {
+					if countParseResult.Error != nil {
+						return countParseResult
+					}
+					loopData.CountNode = countParseResult.Node
+					loopData.HasCount = true
+					countTokensConsumed = countParseResult.TokensConsumed
+				}
+			}
+
 			return ParseResult{
 				GotResult: true,
 				Node: AstNode{
 					Kind: AstKind_WhileLoop,
-					Data: AstData_WhileLoop{
-						BodyNodes: bodyNodes,
-					},
+					Data: loopData,
 				},
-				TokensConsumed: 1 + bodyParseResult.TokensConsumed,
+				TokensConsumed: 1 + bodyParseResult.TokensConsumed + countTokensConsumed,
 			}
 		}
 
+		// `while` and `loop` are keywords, so e.g. a script called `loop` can't be called
+		whileToken := GetToken(index - 1)
 		return ParseResult{
-			GotResult: false,
-			Reason:    WrapStr("Couldn't parse while loop body", bodyParseResult.Reason),
+			GotResult:  true,
+			Error:      errors.New(fmt.Sprintf("'%s' must be followed by '{' (it's a keyword, so it can't be used as a name)", whileToken.Data)),
+			LineNumber: whileToken.LineNumber,
 		}
 	}
 
@@ -1195,7 +1220,6 @@ func BuildAbstractSyntaxTree(parser *Parser) {
 					Error: errors.New("Incomplete script definition"),
 					LineNumber: GetToken(startIndex).LineNumber,
 				}, []AstNode{}
-				break
 			} else if GetKind(index) == TokenKind_RightCurlyBrace {
 				index++
 				break
@@ -1218,6 +1242,9 @@ func BuildAbstractSyntaxTree(parser *Parser) {
 				bodyNodes.MaybeSave(parseResult)
 				index += parseResult.TokensConsumed
 			} else if parseResult := ParseWhileLoop(index); parseResult.GotResult {
+				if parseResult.Error != nil {
+					return parseResult, []AstNode{}
+				}
 				bodyNodes.MaybeSave(parseResult)
 				index += parseResult.TokensConsumed
 			} else if parseResult := ParseComment(index); parseResult.GotResult {
@@ -1428,11 +1455,21 @@ func BuildAbstractSyntaxTree(parser *Parser) {
 				Reason:    fmt.Sprintf("Not a new-line token (%+v)", GetToken(index)),
 			}
 		}
+		newLineData := AstData_NewLine{
+			LineNumber: GetToken(index).LineNumber - 1, // the lexer counts the new-line before saving it
+		}
+		if index > 0 && GetKind(index-1) == TokenKind_SingleLineComment {
+			var originalLineNumber int
+			if _, err := fmt.Sscanf(GetToken(index-1).Data, "// line %d", &originalLineNumber); err == nil {
+				newLineData.OriginalLineNumber = originalLineNumber
+				newLineData.HasOriginalLineNumber = true
+			}
+		}
 		return ParseResult{
 			GotResult: true,
 			Node: AstNode{
 				Kind: AstKind_NewLine,
-				Data: AstData_Empty{},
+				Data: newLineData,
 			},
 			TokensConsumed: 1,
 		}
@@ -1532,6 +1569,10 @@ func (this *AstNodeBuffer) MaybeSave(parseResult ParseResult) {
 			if earlierNode.Kind == AstKind_Comment {
 				i--
 			} else if earlierNode.Kind == AstKind_NewLine {
+				// Keep the line number annotation though, it belongs to the line that's being merged.
+				if newLineData, ok := parseResult.Node.Data.(AstData_NewLine); ok && newLineData.HasOriginalLineNumber {
+					this.Nodes[i].Data = newLineData
+				}
 				return
 			} else {
 				break
@@ -0,0 +1,42 @@
+package compiler
+
+import "strings"
+
+type TargetGameProfile struct {
+	Name string
+
+	// Whether new-lines should carry the line number of the source code (0x02) by default.
+	// Debug builds of the games use these in script assertions and error output. The retail games don't need them,
+	// so none of the profiles below turn them on, but the -lineNumbers flag can.
+	WriteLineNumbers bool
+}
+
+var TargetGameProfiles = []TargetGameProfile{
+	{Name: "thps3"},
+	{Name: "thps4"},
+	{Name: "thug1"},
+	{Name: "thug2"},
+}
+
+func FindTargetGameProfile(name string) (TargetGameProfile, bool) {
+	for _, profile := range TargetGameProfiles {
+		if profile.Name == strings.ToLower(name) {
+			return profile, true
+		}
+	}
+	return TargetGameProfile{}, false
+}
+
+// Sets up the compiler to target the game, with the game's defaults.
+func (profile TargetGameProfile) ApplyTo(bytecodeCompiler *BytecodeCompiler) {
+	bytecodeCompiler.TargetGame = profile.Name
+	bytecodeCompiler.WriteLineNumbers = profile.WriteLineNumbers
+}
+
+func TargetGameNames() []string {
+	names := make([]string, len(TargetGameProfiles))
+	for i, profile := range TargetGameProfiles {
+		names[i] = profile.Name
+	}
+	return names
+}
@@ -0,0 +1,61 @@
+package compiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func compileSourceForTest(t *testing.T, sourceCode string, bytecodeCompiler BytecodeCompiler) []byte {
+	t.Helper()
+	var lexer Lexer
+	var parser Parser
+	lexer.SourceCode = sourceCode
+	lexer.SourceCodeSize = len(sourceCode)
+	if err := LexSourceCode(&lexer); err != nil {
+		t.Fatal(err.ToError())
+	}
+	parser.Tokens = lexer.Tokens
+	BuildAbstractSyntaxTree(&parser)
+	if !parser.Result.GotResult {
+		t.Fatal(parser.Result.Reason)
+	} else if parser.Result.Error != nil {
+		t.Fatal(parser.Result.Error)
+	}
+	bytecodeCompiler.RootAstNode = parser.Result.Node
+	GenerateBytecode(&bytecodeCompiler)
+	return bytecodeCompiler.Bytes
+}
+
+func TestTargetGameProfileLineNumbers(t *testing.T) {
+	sourceCode := "x = 1\n\ny = 2\n"
+	withoutLineNumbers := []byte{
+		0x01, 0x16, 0x7c, 0xe9, 0x23, 0x73, 0x07, 0x17, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x16, 0xea, 0xd9, 0x24, 0x04, 0x07, 0x17, 0x02, 0x00, 0x00, 0x00,
+		0x01,
+	}
+	// Each new-line has the number of the line it ends, and the blank line is merged into the one before it
+	withLineNumbers := []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x16, 0x7c, 0xe9, 0x23, 0x73, 0x07, 0x17, 0x01, 0x00, 0x00, 0x00,
+		0x02, 0x01, 0x00, 0x00, 0x00, 0x16, 0xea, 0xd9, 0x24, 0x04, 0x07, 0x17, 0x02, 0x00, 0x00, 0x00,
+		0x02, 0x03, 0x00, 0x00, 0x00,
+	}
+
+	for _, profile := range TargetGameProfiles {
+		var bytecodeCompiler BytecodeCompiler
+		profile.ApplyTo(&bytecodeCompiler)
+		if qb := compileSourceForTest(t, sourceCode, bytecodeCompiler); !bytes.HasPrefix(qb, withoutLineNumbers) {
+			t.Fatalf("Expected no line numbers for %s by default:\n% x", profile.Name, qb)
+		}
+	}
+
+	// A profile can turn them on by default, and the compiler's setting (e.g. from -lineNumbers) overrides it
+	var bytecodeCompiler BytecodeCompiler
+	TargetGameProfile{Name: "thug2", WriteLineNumbers: true}.ApplyTo(&bytecodeCompiler)
+	if qb := compileSourceForTest(t, sourceCode, bytecodeCompiler); !bytes.HasPrefix(qb, withLineNumbers) {
+		t.Fatalf("Expected line numbers:\n% x", qb)
+	}
+	bytecodeCompiler.WriteLineNumbers = false
+	if qb := compileSourceForTest(t, sourceCode, bytecodeCompiler); !bytes.HasPrefix(qb, withoutLineNumbers) {
+		t.Fatalf("Expected no line numbers:\n% x", qb)
+	}
+}
@@ -1,14 +1,16 @@
+//go:build ignore
+
 package main
 
 import (
     "errors"
     "fmt"
     "github.com/byxor/NeverScript/compiler"
+    "github.com/byxor/NeverScript/decompiler"
     "github.com/pmezard/go-difflib/difflib"
     "io/ioutil"
     "log"
     "os"
-    "os/exec"
     "path/filepath"
     "strings"
 )
@@ -61,11 +63,13 @@ func runTest(targetGame, nsPath, qbPath, roqPath string) error {
     }
 
     decompileQbToRoq := func() (string, error) {
-        roqCmd := exec.Command("roq.exe", "-d", qbPath)
-        decompiledRoqBytes, err := roqCmd.Output()
+        qb, err := ioutil.ReadFile(qbPath)
+        if err != nil { return "", err }
+
+        decompiledRoq, err := decompiler.DecompileRoq(qb)
         if err != nil { return "", err }
 
-        roq := cleanWhitespace(string(decompiledRoqBytes))
+        roq := cleanWhitespace(decompiledRoq)
         return roq, nil
     }
 
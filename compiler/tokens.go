@@ -45,6 +45,7 @@ const (
 	TokenKind_Dot
 	TokenKind_And
 	TokenKind_Or
+	TokenKind_Directive
 	TokenKind_OutOfRange
 )
 
@@ -86,6 +87,7 @@ func (tokenKind TokenKind) String() string {
 		"TokenKind_Dot",
 		"TokenKind_And",
 		"TokenKind_Or",
+		"TokenKind_Directive",
 		"TokenKind_OutOfRange",
 	}[tokenKind]
 }
@@ -6,6 +6,7 @@ import (
     "fmt"
     "math"
     "regexp"
+    "sort"
     "strconv"
     "strings"
     "unicode"
@@ -65,94 +66,106 @@ const (
     Byte_Default           = 0x3F
     Byte_RandomNoRepeat    = 0x40
     Byte_Colon             = 0x42
+    Byte_If2               = 0x47
+    Byte_Else2             = 0x48
 )
 
+// Deeply nested (or maliciously crafted) QB could otherwise overflow the stack.
+const maxNestingDepth = 256
+
+type Settings struct {
+    // Tolerant makes the decompiler emit bytes it doesn't understand as a raw `bytes(...)` block
+    // instead of failing. It resumes decompiling at the next new-line or script boundary.
+    Tolerant bool
+
+    // LineNumberAnnotations makes the decompiler emit the line number stored in line-numbered new-lines (0x02)
+    // as a `// line N` comment at the end of the line. The compiler can read these back to preserve them.
+    LineNumberAnnotations bool
+
+    // ChecksumNames are used for checksums that aren't in the QB's own name table (see ReadChecksumDictionary).
+    ChecksumNames map[uint32]string
+}
+
+// A region of QB that couldn't be decompiled and was emitted as a raw `bytes(...)` block instead.
+type SkippedRegion struct {
+    Offset int
+    Size   int
+}
+
 func Decompile(qb []byte) (string, error) {
+    code, _, err := DecompileWithSettings(qb, Settings{})
+    return code, err
+}
+
+// Reads the names of checksums from the name table (0x2B entries) at the end of the QB.
+func ReadChecksumTable(qb []byte) map[uint32]string {
+    checksumTable := make(map[uint32]string)
+
+    for index := len(qb) - 1; index >= 0; index-- {
+        // find beginning of entry
+        if qb[index] != Byte_ChecksumEntry {
+            continue
+        }
+
+        // found potential checksum entry
+        if index+5 > len(qb) {
+            // too close to the end of the file to be an entry
+            continue
+        }
+        checksum := binary.LittleEndian.Uint32(qb[index+1 : index+5])
+
+        // scan name of checksum
+        nameStart := index + 5
+        nameEnd := nameStart
+        for nameEnd < len(qb) && qb[nameEnd] != 0 {
+            nameEnd++
+        }
+        if nameEnd >= len(qb) {
+            continue
+        }
+        checksumName := string(qb[nameStart:nameEnd])
+
+        // sanity check, may not be a printable checksum
+        isPrintable := false
+        for i, c := range checksumName {
+            if !unicode.IsNumber(c) && !unicode.IsLetter(c) && c != ' ' && c != '_' {
+                break
+            }
+            if i >= len(checksumName)-1 {
+                isPrintable = true
+            }
+        }
+        if isPrintable {
+            checksumTable[checksum] = checksumName
+        }
+    }
+    return checksumTable
+}
+
+func DecompileWithSettings(qb []byte, settings Settings) (string, []SkippedRegion, error) {
 
     var DecompileExpression func(int, int, bool, bool) (string, int, error)
     var DecompileBodyOfCode func(int, int, bool) (string, int, error)
     var DecompileArgument func(int, int, bool) (string, int, error)
     var checksumTable map[uint32]string
+    nestingDepth := 0
 
     GetByte := func(index int) (byte, error) {
-        if index >= len(qb) {
-            //err := errors.New(fmt.Sprintf("Index 0x%x out of range", index))
-            //log.Panic(err)
-            return 0, nil
+        if index < 0 || index >= len(qb) {
+            return 0, errors.New(fmt.Sprintf("Unexpected end of QB - offset 0x%x is out of range (size 0x%x)", index, len(qb)))
         }
         return qb[index], nil
     }
 
     GetBytes := func(index, size int) ([]byte, error) {
-        if index+size > len(qb) {
-            return []byte{}, errors.New(fmt.Sprintf("Index 0x%x out of range", index+size-1))
+        if index < 0 || size < 0 || index > len(qb)-size {
+            return []byte{}, errors.New(fmt.Sprintf("Unexpected end of QB - reading %d bytes at offset 0x%x is out of range (size 0x%x)", size, index, len(qb)))
         }
         return qb[index : index+size], nil
     }
 
-    GetChecksumTable := func() (map[uint32]string, error) {
-        index := len(qb) - 1
-
-        checksumTable := make(map[uint32]string)
-
-        for {
-            if index < 0 {
-                break
-            }
-
-            // find beginning of entry
-            b, err := GetByte(index)
-            if err != nil {
-                return checksumTable, err
-            }
-
-            if b == Byte_ChecksumEntry {
-                startOfChecksum := index
-                // found potential checksum entry
-                index++
-
-                checksumBytes, err := GetBytes(index, 4)
-                if err != nil {
-                    return checksumTable, err
-                }
-
-                checksum := binary.LittleEndian.Uint32(checksumBytes)
-                index += 4
-                checksumNameStartIndex := index
-
-                // scan name of checksum
-                for {
-                    nextByte, err := GetByte(index)
-                    if err != nil {
-                        return checksumTable, err
-                    }
-                    if nextByte == 0 {
-                        index++
-                        break
-                    }
-                    index++
-                }
-                checksumName := string(qb[checksumNameStartIndex : index-1])
-
-                // sanity check, may not be a printable checksum
-                isPrintable := false
-                for i, c := range checksumName {
-                    if !unicode.IsNumber(c) && !unicode.IsLetter(c) && c != ' ' && c != '_' {
-                        break
-                    }
-                    if i >= len(checksumName)-1 {
-                        isPrintable = true
-                    }
-                }
-                if isPrintable {
-                    checksumTable[checksum] = checksumName
-                }
-                index = startOfChecksum - 1
-            }
-
-            index--
-        }
-        return checksumTable, nil
+    IsEndOfQb := func(index int) bool {
+        return index >= len(qb)
     }
 
     Indent := func(indentationLevel int, text string) string {
@@ -181,9 +194,36 @@ func Decompile(qb []byte) (string, error) {
         return errors.New(fmt.Sprintf("%s - 0x%x byte (offset 0x%x)", message, b, offset))
     }
 
+    skippedRegionSizes := make(map[int]int)
+
+    // Scans forward from an unrecognised byte until it's possible to resynchronise with the byte stream.
+    DecompileUnknownBytes := func(index int) (string, int) {
+        initialIndex := index
+        var hexBytes []string
+        for {
+            hexBytes = append(hexBytes, fmt.Sprintf("%02x", qb[index]))
+            index++
+            if index >= len(qb) {
+                break
+            }
+            b := qb[index]
+            if b == Byte_NewLine || b == Byte_NewLineWithNumber || b == Byte_Script || b == Byte_EndScript {
+                break
+            }
+        }
+        skippedRegionSizes[initialIndex] = index - initialIndex
+        return fmt.Sprintf("bytes(%s)", strings.Join(hexBytes, " ")), index - initialIndex
+    }
+
     DecompileNewLineWithNumber := func(index int) (string, int, error) {
-        //lineNumber := int(binary.LittleEndian.Uint32(qb[index : index+4]))
-        //result := fmt.Sprintf("\n/* Line number 0x%x */", lineNumber)
+        lineNumberBytes, err := GetBytes(index+1, 4)
+        if err != nil {
+            return "", 0, err
+        }
+        if settings.LineNumberAnnotations {
+            lineNumber := binary.LittleEndian.Uint32(lineNumberBytes)
+            return fmt.Sprintf("// line %d\n", lineNumber), 5, nil
+        }
         return "\n", 5, nil
     }
 
@@ -191,6 +231,9 @@ func Decompile(qb []byte) (string, error) {
         initialIndex := index
         var newLinesAfterEqualsCode strings.Builder
         for {
+            if IsEndOfQb(index) {
+                break
+            }
             nextByte, err := GetByte(index)
             if err != nil {
                 return "", 0, err
@@ -215,10 +258,19 @@ func Decompile(qb []byte) (string, error) {
     DecompileString := func(index int) (string, int, error) {
         initialIndex := index
         index++
-        lengthBytes := qb[index : index+4]
+        lengthBytes, err := GetBytes(index, 4)
+        if err != nil {
+            return "", 0, err
+        }
         length := int(binary.LittleEndian.Uint32(lengthBytes))
+        if length < 1 {
+            return "", 0, DecompilerError("String has no null terminator", qb[initialIndex], initialIndex)
+        }
         index += 4
-        stringBytes := qb[index : index+length]
+        stringBytes, err := GetBytes(index, length)
+        if err != nil {
+            return "", 0, err
+        }
 
         stringString := string(stringBytes)
         stringString = strings.ReplaceAll(stringString, "\\", "\\\\")
@@ -245,7 +297,10 @@ func Decompile(qb []byte) (string, error) {
         }
 
         index++
-        checksumBytes := qb[index : index+4]
+        checksumBytes, err := GetBytes(index, 4)
+        if err != nil {
+            return "", 0, err
+        }
         index += 4
 
         var checksumCode string
@@ -289,6 +344,14 @@ func Decompile(qb []byte) (string, error) {
     DecompileAssignment := func(index, indentationLevel int, shouldPadEquals bool) (string, int, error) {
         initialIndex := index
 
+        b, err := GetByte(index)
+        if err != nil {
+            return "", 0, err
+        }
+        if b != Byte_Checksum && b != Byte_Local {
+            return "", 0, DecompilerError("Assignment doesn't start with a checksum", b, index)
+        }
+
         checksumCode, bytesRead, err := DecompileChecksum(index)
         if err != nil {
             return "", 0, err
@@ -329,6 +392,12 @@ func Decompile(qb []byte) (string, error) {
     }
 
     DecompileBodyOfCode = func(index, indentationLevel int, shouldPadEquals bool) (string, int, error) {
+        nestingDepth++
+        defer func() { nestingDepth-- }()
+        if nestingDepth > maxNestingDepth {
+            return "", 0, errors.New(fmt.Sprintf("Code is nested too deeply (offset 0x%x)", index))
+        }
+
         var currentLineCode strings.Builder
         var bodyOfCode strings.Builder
         flushCurrentLine := func() {
@@ -340,6 +409,10 @@ func Decompile(qb []byte) (string, error) {
 
         firstIteration := true
         for {
+            if IsEndOfQb(index) {
+                break
+            }
+
             b, err := GetByte(index)
             if err != nil {
                 return "", 0, err
@@ -523,6 +596,10 @@ func Decompile(qb []byte) (string, error) {
                 index += bytesRead
             } else if b == Byte_EndScript || b == Byte_EndStruct || b == Byte_EndArray || b == Byte_EndIf || b == Byte_Else || b == Byte_EndWhile || b == Byte_EndSwitch || b == Byte_LongJump || b == Byte_EndOfFile || b == Byte_ChecksumEntry {
                 break
+            } else if settings.Tolerant {
+                bytesCode, bytesRead := DecompileUnknownBytes(index)
+                index += bytesRead
+                currentLineCode.WriteString(bytesCode)
             } else {
                 return "", 0, DecompilerError("Byte not recognised in body of code", b, index)
             }
@@ -531,7 +608,12 @@ func Decompile(qb []byte) (string, error) {
         }
 
         flushCurrentLine()
-        return TrimWhitespace(bodyOfCode.String()), index - initialIndex, nil
+        bodyCode := TrimWhitespace(bodyOfCode.String())
+        if settings.LineNumberAnnotations && indentationLevel > 0 && strings.HasPrefix(bodyCode, "// line") {
+            // separate the annotation from the opening brace of the block
+            bodyCode = " " + bodyCode
+        }
+        return bodyCode, index - initialIndex, nil
     }
 
     DecompileArgument = func(index, indentationLevel int, shouldPadEquals bool) (string, int, error) {
@@ -752,6 +834,10 @@ func Decompile(qb []byte) (string, error) {
             numberOfBranches := int(binary.LittleEndian.Uint32(numberOfBranchesBytes))
             index += 4
 
+            if numberOfBranches < 1 || numberOfBranches > (len(qb)-index)/4 {
+                return "", 0, DecompilerError(fmt.Sprintf("Random has an invalid number of branches (%d)", numberOfBranches), b, initialIndex)
+            }
+
             branchOffsets := make([]int, numberOfBranches)
             for i := 0; i < numberOfBranches; i++ {
                 branchSizeBytes, err := GetBytes(index, 4)
@@ -983,21 +1069,33 @@ func Decompile(qb []byte) (string, error) {
         return atomCode, index - initialIndex, err
     }
 
-    checksumTable, err := GetChecksumTable()
-    if err != nil {
-        return "", err
-    }
+    checksumTable = withChecksumNames(ReadChecksumTable(qb), settings.ChecksumNames)
 
     var output strings.Builder
     index := 0
 
-    rootCode, bytesRead, err := DecompileBodyOfCode(0, 0, true)
-    if err != nil {
-        return "", err
-    }
-    index += bytesRead
+    for {
+        rootCode, bytesRead, err := DecompileBodyOfCode(index, 0, true)
+        if err != nil {
+            return "", nil, err
+        }
+        index += bytesRead
+
+        output.WriteString(rootCode)
+
+        if !settings.Tolerant || index >= len(qb) {
+            break
+        }
 
-    output.WriteString(rootCode)
+        // The root body stopped early on a byte that doesn't belong there, skip over it and carry on
+        b, _ := GetByte(index)
+        if b == Byte_EndOfFile || b == Byte_ChecksumEntry {
+            break
+        }
+        bytesCode, bytesRead := DecompileUnknownBytes(index)
+        index += bytesRead
+        output.WriteString("\n" + bytesCode + "\n")
+    }
 
     for {
         if index >= len(qb) {
@@ -1006,7 +1104,7 @@ func Decompile(qb []byte) (string, error) {
 
         b, err := GetByte(index)
         if err != nil {
-            return "", err
+            return "", nil, err
         }
 
         if b == Byte_EndOfFile {
@@ -1018,7 +1116,7 @@ func Decompile(qb []byte) (string, error) {
             for {
                 nextByte, err := GetByte(index)
                 if err != nil {
-                    return "", err
+                    return "", nil, err
                 }
                 if nextByte == 0 {
                     index++
@@ -1034,8 +1132,16 @@ func Decompile(qb []byte) (string, error) {
     if index < len(qb) {
         nextByte, _ := GetByte(index)
         message := fmt.Sprintf("Did not finish decompiling.\n%s\n0x%x/0x%x bytes decompiled.\nnext byte: 0x%x", output.String(), index, len(qb), nextByte)
-        return "", errors.New(message)
+        return "", nil, errors.New(message)
+    }
+
+    skippedRegions := make([]SkippedRegion, 0, len(skippedRegionSizes))
+    for offset, size := range skippedRegionSizes {
+        skippedRegions = append(skippedRegions, SkippedRegion{Offset: offset, Size: size})
     }
+    sort.Slice(skippedRegions, func(i, j int) bool {
+        return skippedRegions[i].Offset < skippedRegions[j].Offset
+    })
 
-    return output.String(), nil
+    return output.String(), skippedRegions, nil
 }
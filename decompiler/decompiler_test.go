@@ -0,0 +1,257 @@
+package decompiler
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "github.com/byxor/NeverScript/compiler"
+    "math"
+    "strconv"
+    "strings"
+    "testing"
+    "unicode"
+)
+
+func FuzzDecompile(f *testing.F) {
+    for _, sourceCode := range []string{
+        "x = 10\n",
+        "my_struct = { x=1, y=2.5, z=\"three\" }\nmy_array = [(1.0, 2.0), (1.0, 2.0, 3.0)]\n",
+        "script Foo {\n    <x> = (1 + 2)\n    if <x> {\n        Bar a=<x> <...>\n    } else {\n        return\n    }\n}\n",
+        "script Foo {\n    while {\n        Wait 1 gameframe\n        break\n    }\n}\n",
+        "script Foo {\n    while {\n        Wait 1 gameframe\n    } 5\n    while { Bar } <n>\n}\n",
+    } {
+        qb, err := compileForTest(sourceCode, "thps4")
+        if err != nil {
+            f.Fatal(err)
+        }
+        f.Add(qb)
+    }
+    f.Add([]byte{})
+    f.Add([]byte{Byte_String, 0xFF, 0xFF, 0xFF, 0xFF})
+    f.Add([]byte{Byte_Random, 0xFF, 0xFF, 0xFF, 0x0F, 0x00})
+
+    f.Fuzz(func(t *testing.T, qb []byte) {
+        // Errors are fine, panics are not.
+        Decompile(qb)
+        DecompileWithSettings(qb, Settings{Tolerant: true})
+    })
+}
+
+func FuzzCompileDecompile(f *testing.F) {
+    f.Add("my_global", int32(10), float32(0.5), "hello")
+    f.Add("x", int32(-2147483648), float32(-1234.5678), "")
+    f.Add("`a b`", int32(0), float32(0), "\\\"")
+
+    f.Fuzz(func(t *testing.T, name string, integer int32, float float32, text string) {
+        if math.IsNaN(float64(float)) || math.IsInf(float64(float), 0) {
+            t.Skip()
+        }
+        name = sanitiseIdentifier(name)
+        for _, usedName := range []string{"x", "y", "value", "text", "Wait", "gameframe"} {
+            // Checksums ignore case, so the name table would only keep one spelling and the QB wouldn't round-trip
+            if strings.EqualFold(name, usedName) {
+                name = "id_" + name
+            }
+        }
+        floatCode := strconv.FormatFloat(float64(float), 'f', -1, 32)
+        if !strings.Contains(floatCode, ".") {
+            floatCode += ".0"
+        }
+        text = strings.Map(func(r rune) rune {
+            if r == '"' || r == '\\' || r == '\n' || r == '\r' || r == 0 {
+                return -1
+            }
+            return r
+        }, text)
+
+        sourceCode := fmt.Sprintf(
+            "%s = %d\nscript %s {\n    <x> = %s\n    <y> = \"%s\"\n    %s value=(1.0, %s) text=<y>\n    while {\n        Wait 1 gameframe\n    } %d\n}\n",
+            name, integer, name, floatCode, text, name, floatCode, integer,
+        )
+
+        for _, targetGame := range []string{"thps3", "thps4", "thug1", "thug2"} {
+            qb, err := compileForTest(sourceCode, targetGame)
+            if err != nil {
+                t.Fatalf("Failed to compile:\n%s\n%s", sourceCode, err)
+            }
+            code, err := Decompile(qb)
+            if err != nil {
+                t.Fatalf("Failed to decompile output of:\n%s\n%s", sourceCode, err)
+            }
+            if !strings.Contains(code, fmt.Sprintf("= %d", integer)) {
+                t.Fatalf("Decompiled code is missing %d:\n%s", integer, code)
+            }
+            recompiledQb, err := compileForTest(code, targetGame)
+            if err != nil {
+                t.Fatalf("Failed to recompile decompiled code:\n%s\n%s", code, err)
+            }
+            if !bytes.Equal(recompiledQb, qb) {
+                t.Fatalf("Decompiled code compiles differently for %s:\n%s\nExpected: % x\nGot:      % x", targetGame, code, qb, recompiledQb)
+            }
+        }
+    })
+}
+
+func TestLoopRoundTrip(t *testing.T) {
+    sourceCode := "script Foo {\n    while {\n        Wait 1 gameframe\n    } 5\n    while {\n        Bar\n        break\n    } <n>\n}\n"
+    for _, targetGame := range compiler.TargetGameNames() {
+        qb, err := compileForTest(sourceCode, targetGame)
+        if err != nil {
+            t.Fatal(err)
+        }
+        code, err := Decompile(qb)
+        if err != nil {
+            t.Fatalf("Failed to decompile for %s: %s", targetGame, err)
+        }
+        if !strings.Contains(code, "loop {") || !strings.Contains(code, "} 5") {
+            t.Fatalf("Expected counted loops for %s:\n%s", targetGame, code)
+        }
+
+        // The compiler reads `loop` the same as `while`
+        recompiledQb, err := compileForTest(code, targetGame)
+        if err != nil {
+            t.Fatalf("Failed to recompile for %s:\n%s\n%s", targetGame, code, err)
+        }
+        if !bytes.Equal(recompiledQb, qb) {
+            t.Fatalf("Decompiled code compiles differently for %s:\n%s\nExpected: % x\nGot:      % x", targetGame, code, qb, recompiledQb)
+        }
+    }
+}
+
+func TestThug2InfiniteLoopBypasser(t *testing.T) {
+    bypasser := make([]byte, 4)
+    binary.LittleEndian.PutUint32(bypasser, compiler.StringToChecksum("__COMPILER__infinite_loop_bypasser_0"))
+
+    infiniteLoop, err := compileForTest("script Foo {\n    while {\n        Bar\n    }\n}\n", "thug2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Contains(infiniteLoop, bypasser) || !bytes.Contains(infiniteLoop, []byte{Byte_If2}) {
+        t.Fatalf("Expected the infinite loop to get past THUG2's checks:\n% x", infiniteLoop)
+    }
+    sameLoop, err := compileForTest("script Foo {\n    loop {\n        Bar\n    }\n}\n", "thug2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(sameLoop, infiniteLoop) {
+        t.Fatalf("Expected `loop` to compile the same as `while`:\nExpected: % x\nGot:      % x", infiniteLoop, sameLoop)
+    }
+
+    // Counted loops always end, so they're left alone
+    countedLoop, err := compileForTest("script Foo {\n    while {\n        Bar\n    } 3\n}\n", "thug2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if bytes.Contains(countedLoop, bypasser) || bytes.Contains(countedLoop, []byte{Byte_If2}) {
+        t.Fatalf("Expected the counted loop to be left alone:\n% x", countedLoop)
+    }
+}
+
+func TestTolerantDecompilation(t *testing.T) {
+    qb := []byte{
+        Byte_NewLine, Byte_Script, Byte_Checksum, 0x01, 0x00, 0x00, 0x00,
+        Byte_NewLine, Byte_Checksum, 0x02, 0x00, 0x00, 0x00,
+        Byte_NewLine, 0x99, 0x98, Byte_Checksum, 0x03, 0x00, 0x00, 0x00,
+        Byte_NewLine, Byte_EndScript,
+        Byte_NewLine, 0x97,
+        Byte_NewLine, Byte_EndOfFile,
+    }
+
+    if _, _, err := DecompileWithSettings(qb, Settings{}); err == nil || !strings.Contains(err.Error(), "0x99 byte (offset 0xe)") {
+        t.Fatalf("Expected an error about the 0x99 byte, got: %v", err)
+    }
+
+    code, skippedRegions, err := DecompileWithSettings(qb, Settings{Tolerant: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedCode := "\nscript #01000000 {\n    #02000000 \n    bytes(99 98 16 03 00 00 00) \n} \nbytes(97) \n"
+    if code != expectedCode {
+        t.Fatalf("Expected:\n%q\nGot:\n%q", expectedCode, code)
+    }
+    expectedRegions := []SkippedRegion{{Offset: 0xe, Size: 7}, {Offset: 0x18, Size: 1}}
+    if fmt.Sprint(skippedRegions) != fmt.Sprint(expectedRegions) {
+        t.Fatalf("Expected skipped regions %v, got %v", expectedRegions, skippedRegions)
+    }
+}
+
+func TestLineNumberAnnotations(t *testing.T) {
+    qb := []byte{
+        Byte_NewLineWithNumber, 0x01, 0x00, 0x00, 0x00,
+        Byte_Checksum, 0x01, 0x00, 0x00, 0x00, Byte_Equals, Byte_Integer, 0x05, 0x00, 0x00, 0x00,
+        Byte_NewLineWithNumber, 0x03, 0x00, 0x00, 0x00,
+        Byte_Checksum, 0x02, 0x00, 0x00, 0x00,
+        Byte_NewLineWithNumber, 0x04, 0x00, 0x00, 0x00,
+        Byte_EndOfFile,
+    }
+
+    code, _, err := DecompileWithSettings(qb, Settings{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(code, "// line") {
+        t.Fatalf("Expected no line comments without LineNumberAnnotations:\n%s", code)
+    }
+
+    // The comment goes at the end of the line that the new-line finishes
+    code, _, err = DecompileWithSettings(qb, Settings{LineNumberAnnotations: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedCode := "// line 1\n#01000000 = 5 // line 3\n#02000000 // line 4\n"
+    if code != expectedCode {
+        t.Fatalf("Expected:\n%q\nGot:\n%q", expectedCode, code)
+    }
+
+    recompiledQb, err := compileForTestWithSettings(code, compiler.BytecodeCompiler{PreserveLineNumbers: true})
+    if err != nil {
+        t.Fatalf("Failed to compile:\n%s\n%s", code, err)
+    }
+    if !bytes.Equal(recompiledQb, qb) {
+        t.Fatalf("Line numbers weren't preserved:\nExpected: % x\nGot:      % x", qb, recompiledQb)
+    }
+}
+
+func compileForTest(sourceCode, targetGame string) ([]byte, error) {
+    return compileForTestWithSettings(sourceCode, compiler.BytecodeCompiler{TargetGame: targetGame})
+}
+
+func compileForTestWithSettings(sourceCode string, bytecodeCompiler compiler.BytecodeCompiler) ([]byte, error) {
+    var lexer compiler.Lexer
+    var parser compiler.Parser
+    lexer.SourceCode = sourceCode
+    lexer.SourceCodeSize = len(sourceCode)
+    if err := compiler.LexSourceCode(&lexer); err != nil {
+        return nil, err.ToError()
+    }
+    parser.Tokens = lexer.Tokens
+    compiler.BuildAbstractSyntaxTree(&parser)
+    if !parser.Result.GotResult {
+        return nil, errors.New(parser.Result.Reason)
+    } else if parser.Result.Error != nil {
+        return nil, parser.Result.Error
+    }
+    bytecodeCompiler.RootAstNode = parser.Result.Node
+    compiler.GenerateBytecode(&bytecodeCompiler)
+    return bytecodeCompiler.Bytes, nil
+}
+
+func sanitiseIdentifier(name string) string {
+    name = strings.Map(func(r rune) rune {
+        if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+            return r
+        }
+        return -1
+    }, name)
+    isKeyword := false
+    for _, keyword := range []string{"if", "else", "and", "or", "while", "loop", "break", "script", "random", "return"} {
+        if strings.EqualFold(name, keyword) {
+            isKeyword = true
+        }
+    }
+    if name == "" || isKeyword || !unicode.IsLetter(rune(name[0])) {
+        name = "id_" + name
+    }
+    return name
+}
@@ -0,0 +1,44 @@
+package decompiler
+
+import (
+    "github.com/byxor/NeverScript/compiler"
+    "io/ioutil"
+    "strings"
+)
+
+// Reads a checksum dictionary: a text file with one name per line, e.g. a list of every script name in a game.
+// QBs often have no name table, so a dictionary lets the decompiler show names instead of `#xxxxxxxx` checksums.
+// Blank lines and lines starting with '#' are skipped.
+func ReadChecksumDictionary(path string) (map[uint32]string, error) {
+    text, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return ParseChecksumDictionary(string(text)), nil
+}
+
+func ParseChecksumDictionary(text string) map[uint32]string {
+    checksumNames := make(map[uint32]string)
+    for _, line := range strings.Split(text, "\n") {
+        name := strings.TrimSpace(line)
+        if name == "" || strings.HasPrefix(name, "#") {
+            continue
+        }
+        checksum := compiler.StringToChecksum(name)
+        // The first spelling wins, e.g. "DoStuff" over a later "dostuff"
+        if _, found := checksumNames[checksum]; !found {
+            checksumNames[checksum] = name
+        }
+    }
+    return checksumNames
+}
+
+// Adds the extra names to a checksum table without replacing the names the QB already has.
+func withChecksumNames(checksumTable map[uint32]string, extraNames map[uint32]string) map[uint32]string {
+    for checksum, name := range extraNames {
+        if _, found := checksumTable[checksum]; !found {
+            checksumTable[checksum] = name
+        }
+    }
+    return checksumTable
+}
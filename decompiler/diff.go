@@ -0,0 +1,222 @@
+package decompiler
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "github.com/pmezard/go-difflib/difflib"
+    "strings"
+)
+
+const (
+    ItemKind_Global = "global"
+    ItemKind_Script = "script"
+)
+
+// A global assignment or script at the root of a QB file.
+type Item struct {
+    Kind   string
+    Name   string
+    Offset int
+    Bytes  []byte
+}
+
+// Identifies an item across files. Globals can be assigned more than once, so later assignments are numbered.
+func (this Item) key(occurrence int) string {
+    if occurrence > 1 {
+        return fmt.Sprintf("%s %s (#%d)", this.Kind, this.Name, occurrence)
+    }
+    return fmt.Sprintf("%s %s", this.Kind, this.Name)
+}
+
+// Splits a QB file into its globals and scripts. Anything else at the root of the file (e.g. new-lines) is skipped.
+// The name table is returned too, so items can be decompiled on their own.
+func SplitItems(qb []byte) ([]Item, []byte, error) {
+    instructions, err := Disassemble(qb)
+    if err != nil {
+        return nil, nil, err
+    }
+    checksumTable := ReadChecksumTable(qb)
+
+    nameOf := func(instruction Instruction) string {
+        checksumBytes := instruction.Bytes[1:5]
+        checksum := binary.LittleEndian.Uint32(checksumBytes)
+        if name, found := checksumTable[checksum]; found {
+            return name
+        }
+        return fmt.Sprintf("#%02x%02x%02x%02x", checksumBytes[0], checksumBytes[1], checksumBytes[2], checksumBytes[3])
+    }
+
+    endOfCode := len(qb)
+    items := []Item{}
+
+    for i := 0; i < len(instructions); i++ {
+        instruction := instructions[i]
+
+        if instruction.Opcode == Byte_EndOfFile || instruction.Opcode == Byte_ChecksumEntry {
+            endOfCode = instruction.Offset
+            break
+        }
+
+        isScript := instruction.Opcode == Byte_Script && i+1 < len(instructions) && instructions[i+1].Opcode == Byte_Checksum
+        isGlobal := instruction.Opcode == Byte_Checksum && i+1 < len(instructions) && instructions[i+1].Opcode == Byte_Equals
+        if !isScript && !isGlobal {
+            continue
+        }
+
+        item := Item{
+            Kind:   ItemKind_Global,
+            Offset: instruction.Offset,
+        }
+        if isScript {
+            item.Kind = ItemKind_Script
+            item.Name = nameOf(instructions[i+1])
+        } else {
+            item.Name = nameOf(instruction)
+        }
+
+        // find the end of the item
+        depth := 0
+        j := i + 1
+        for ; j < len(instructions); j++ {
+            opcode := instructions[j].Opcode
+            if isScript {
+                if opcode == Byte_EndScript {
+                    j++
+                    break
+                }
+                continue
+            }
+            if opcode == Byte_Struct || opcode == Byte_Array {
+                depth++
+            } else if opcode == Byte_EndStruct || opcode == Byte_EndArray {
+                depth--
+            } else if depth <= 0 && (opcode == Byte_NewLine || opcode == Byte_NewLineWithNumber || opcode == Byte_EndOfFile || opcode == Byte_ChecksumEntry) {
+                break
+            }
+        }
+
+        end := len(qb)
+        if j < len(instructions) {
+            end = instructions[j].Offset
+        }
+        item.Bytes = qb[item.Offset:end]
+        items = append(items, item)
+        i = j - 1
+    }
+
+    return items, qb[endOfCode:], nil
+}
+
+// Compares two QB files item by item (globals and scripts), showing a NeverScript diff and a disassembly diff for
+// each item that changed.
+func Diff(qbA, qbB []byte, nameA, nameB string) (string, error) {
+    itemsA, nameTableA, err := SplitItems(qbA)
+    if err != nil {
+        return "", errors.New(fmt.Sprintf("%s: %s", nameA, err))
+    }
+    itemsB, nameTableB, err := SplitItems(qbB)
+    if err != nil {
+        return "", errors.New(fmt.Sprintf("%s: %s", nameB, err))
+    }
+
+    keyItems := func(items []Item) ([]string, map[string]Item) {
+        keys := []string{}
+        itemsByKey := make(map[string]Item)
+        occurrences := make(map[string]int)
+        for _, item := range items {
+            occurrences[item.key(1)]++
+            key := item.key(occurrences[item.key(1)])
+            keys = append(keys, key)
+            itemsByKey[key] = item
+        }
+        return keys, itemsByKey
+    }
+    keysA, itemsByKeyA := keyItems(itemsA)
+    keysB, itemsByKeyB := keyItems(itemsB)
+
+    // the item on its own, with the name table so checksums keep their names
+    standalone := func(item Item, nameTable []byte) []byte {
+        var qb []byte
+        qb = append(qb, Byte_NewLine)
+        qb = append(qb, item.Bytes...)
+        qb = append(qb, Byte_NewLine)
+        return append(qb, nameTable...)
+    }
+
+    code := func(item Item, nameTable []byte) string {
+        code, err := Decompile(standalone(item, nameTable))
+        if err != nil {
+            return fmt.Sprintf("// couldn't decompile: %s\n", err)
+        }
+        return strings.TrimSpace(code) + "\n"
+    }
+
+    // offsets are relative to the start of the item so unrelated changes earlier in the file don't show up
+    disassembly := func(item Item, nameTable []byte) string {
+        instructions, _ := Disassemble(standalone(item, nameTable))
+        var itemInstructions []Instruction
+        for _, instruction := range instructions {
+            if instruction.Offset >= 1 && instruction.Offset < 1+len(item.Bytes) {
+                instruction.Offset -= 1
+                for k := range instruction.JumpTargets {
+                    instruction.JumpTargets[k] -= 1
+                }
+                itemInstructions = append(itemInstructions, instruction)
+            }
+        }
+        return FormatDisassembly(itemInstructions)
+    }
+
+    unifiedDiff := func(a, b, fromFile, toFile string) string {
+        diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+            A:        difflib.SplitLines(a),
+            B:        difflib.SplitLines(b),
+            FromFile: fromFile,
+            ToFile:   toFile,
+            Context:  3,
+        })
+        return diff
+    }
+
+    var report strings.Builder
+    numberOfChanges := 0
+
+    for _, key := range keysA {
+        if _, found := itemsByKeyB[key]; !found {
+            item := itemsByKeyA[key]
+            report.WriteString(fmt.Sprintf("removed: %s (0x%x, %d bytes)\n", key, item.Offset, len(item.Bytes)))
+            numberOfChanges++
+        }
+    }
+
+    for _, key := range keysB {
+        itemB := itemsByKeyB[key]
+        itemA, found := itemsByKeyA[key]
+        if !found {
+            report.WriteString(fmt.Sprintf("added: %s (0x%x, %d bytes)\n", key, itemB.Offset, len(itemB.Bytes)))
+            numberOfChanges++
+            continue
+        }
+
+        codeA, codeB := code(itemA, nameTableA), code(itemB, nameTableB)
+        disassemblyA, disassemblyB := disassembly(itemA, nameTableA), disassembly(itemB, nameTableB)
+        if bytes.Equal(itemA.Bytes, itemB.Bytes) && codeA == codeB {
+            continue
+        }
+        numberOfChanges++
+
+        report.WriteString(fmt.Sprintf("changed: %s (0x%x, %d bytes -> 0x%x, %d bytes)\n", key, itemA.Offset, len(itemA.Bytes), itemB.Offset, len(itemB.Bytes)))
+        report.WriteString(unifiedDiff(codeA, codeB, nameA+": "+key, nameB+": "+key))
+        if disassemblyA != disassemblyB {
+            report.WriteString(unifiedDiff(disassemblyA, disassemblyB, nameA+" (bytes)", nameB+" (bytes)"))
+        }
+        report.WriteString("\n")
+    }
+
+    if numberOfChanges == 0 {
+        return "No differences.\n", nil
+    }
+    return report.String(), nil
+}
@@ -0,0 +1,84 @@
+package decompiler
+
+import (
+    "testing"
+)
+
+func TestDiff(t *testing.T) {
+    qbA, err := compileForTest("x = 10\nscript Foo {\n    Bar\n}\nscript Gone {\n    Bar\n}\n", "thps4")
+    if err != nil {
+        t.Fatal(err)
+    }
+    qbB, err := compileForTest("x = 10\nscript Foo {\n    Baz 2\n}\nscript New {\n}\n", "thps4")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    report, err := Diff(qbA, qbB, "a.qb", "b.qb")
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedReport := "removed: script Gone (0x1c, 14 bytes)\n" +
+        "changed: script Foo (0xd, 14 bytes -> 0xd, 19 bytes)\n" +
+        "--- a.qb: script Foo\n" +
+        "+++ b.qb: script Foo\n" +
+        "@@ -1,4 +1,4 @@\n" +
+        " script Foo {\n" +
+        "-    Bar \n" +
+        "+    Baz 2 \n" +
+        " }\n" +
+        " \n" +
+        "--- a.qb (bytes)\n" +
+        "+++ b.qb (bytes)\n" +
+        "@@ -1,7 +1,8 @@\n" +
+        " 00000000  23                         Script\n" +
+        " 00000001  16 de 9a 8c 73             Checksum           0x738c9ade Foo\n" +
+        " 00000006  01                         NewLine\n" +
+        "-00000007  16 55 73 00 89             Checksum           0x89007355 Bar\n" +
+        "-0000000c  01                         NewLine\n" +
+        "-0000000d  24                         EndScript\n" +
+        "+00000007  16 67 fb db 87             Checksum           0x87dbfb67 Baz\n" +
+        "+0000000c  17 02 00 00 00             Integer            2\n" +
+        "+00000011  01                         NewLine\n" +
+        "+00000012  24                         EndScript\n" +
+        " \n" +
+        "\n" +
+        "added: script New (0x21, 8 bytes)\n"
+    if report != expectedReport {
+        t.Fatalf("Expected:\n%s\nGot:\n%s", expectedReport, report)
+    }
+}
+
+func TestDiffWithoutDifferences(t *testing.T) {
+    qb, err := compileForTest("x = 10\nx = 20\nscript Foo {\n    Bar\n}\n", "thps4")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    report, err := Diff(qb, qb, "a.qb", "b.qb")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if report != "No differences.\n" {
+        t.Fatalf("Expected no differences, got:\n%s", report)
+    }
+}
+
+func TestDiffRepeatedGlobals(t *testing.T) {
+    qbA, err := compileForTest("x = 10\nx = 20\n", "thps4")
+    if err != nil {
+        t.Fatal(err)
+    }
+    qbB, err := compileForTest("x = 10\n", "thps4")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    report, err := Diff(qbA, qbB, "a.qb", "b.qb")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if expectedReport := "removed: global x (#2) (0xd, 11 bytes)\n"; report != expectedReport {
+        t.Fatalf("Expected:\n%s\nGot:\n%s", expectedReport, report)
+    }
+}
@@ -0,0 +1,273 @@
+package decompiler
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+type Instruction struct {
+    Offset      int
+    Bytes       []byte
+    Opcode      byte
+    Name        string
+    Operand     string
+    JumpTargets []int
+}
+
+var opcodeNames = map[byte]string{
+    Byte_EndOfFile:         "EndOfFile",
+    Byte_NewLine:           "NewLine",
+    Byte_NewLineWithNumber: "NewLineWithNumber",
+    Byte_Struct:            "Struct",
+    Byte_EndStruct:         "EndStruct",
+    Byte_Array:             "Array",
+    Byte_EndArray:          "EndArray",
+    Byte_Equals:            "Equals",
+    Byte_Dot:               "Dot",
+    Byte_Comma:             "Comma",
+    Byte_Minus:             "Minus",
+    Byte_Plus:              "Plus",
+    Byte_Divide:            "Divide",
+    Byte_Multiply:          "Multiply",
+    Byte_Parenthesis:       "Parenthesis",
+    Byte_EndParenthesis:    "EndParenthesis",
+    Byte_EqualTo:           "EqualTo",
+    Byte_LessThan:          "LessThan",
+    Byte_LessThanEqual:     "LessThanEqual",
+    Byte_GreaterThan:       "GreaterThan",
+    Byte_GreaterThanEqual:  "GreaterThanEqual",
+    Byte_Checksum:          "Checksum",
+    Byte_Integer:           "Integer",
+    Byte_Float:             "Float",
+    Byte_String:            "String",
+    Byte_LocalString:       "LocalString",
+    Byte_Vector:            "Vector",
+    Byte_Pair:              "Pair",
+    Byte_While:             "While",
+    Byte_EndWhile:          "EndWhile",
+    Byte_Break:             "Break",
+    Byte_Script:            "Script",
+    Byte_EndScript:         "EndScript",
+    Byte_If:                "If",
+    Byte_Else:              "Else",
+    Byte_EndIf:             "EndIf",
+    Byte_Return:            "Return",
+    Byte_ChecksumEntry:     "ChecksumEntry",
+    Byte_AllArguments:      "AllArguments",
+    Byte_Local:             "Local",
+    Byte_LongJump:          "LongJump",
+    Byte_Random:            "Random",
+    Byte_RandomRange:       "RandomRange",
+    Byte_Or:                "Or",
+    Byte_And:               "And",
+    Byte_Xor:               "Xor",
+    Byte_Not:               "Not",
+    Byte_Switch:            "Switch",
+    Byte_EndSwitch:         "EndSwitch",
+    Byte_Case:              "Case",
+    Byte_Default:           "Default",
+    Byte_RandomNoRepeat:    "RandomNoRepeat",
+    Byte_Colon:             "Colon",
+    Byte_If2:               "If2",
+    Byte_Else2:             "Else2",
+}
+
+// Walks the QB opcode stream and decodes the operand of each instruction.
+// Bytes that aren't recognised as opcodes are listed as "Unknown" one at a time, so the listing always covers the whole file.
+func Disassemble(qb []byte) ([]Instruction, error) {
+    return DisassembleWithChecksumNames(qb, nil)
+}
+
+// Like Disassemble, using extra names for checksums that aren't in the QB's own name table.
+func DisassembleWithChecksumNames(qb []byte, checksumNames map[uint32]string) ([]Instruction, error) {
+    checksumTable := withChecksumNames(ReadChecksumTable(qb), checksumNames)
+    instructions := []Instruction{}
+
+    index := 0
+    for index < len(qb) {
+        opcode := qb[index]
+        instruction := Instruction{
+            Offset: index,
+            Opcode: opcode,
+            Name:   "Unknown",
+        }
+        if name, found := opcodeNames[opcode]; found {
+            instruction.Name = name
+        }
+
+        size, err := decodeOperand(qb, &instruction, checksumTable)
+        if err != nil {
+            return instructions, err
+        }
+
+        instruction.Bytes = qb[index : index+size]
+        instructions = append(instructions, instruction)
+        index += size
+    }
+
+    return instructions, nil
+}
+
+// Decodes the operand of the instruction and returns its full size, including the opcode.
+func decodeOperand(qb []byte, instruction *Instruction, checksumTable map[uint32]string) (int, error) {
+    index := instruction.Offset + 1
+
+    need := func(size int) error {
+        if index+size > len(qb) {
+            return errors.New(fmt.Sprintf("Unexpected end of QB - %s at offset 0x%x needs %d more bytes", instruction.Name, instruction.Offset, index+size-len(qb)))
+        }
+        return nil
+    }
+
+    readUint32 := func() uint32 {
+        value := binary.LittleEndian.Uint32(qb[index : index+4])
+        index += 4
+        return value
+    }
+
+    readFloat := func() string {
+        return strconv.FormatFloat(float64(math.Float32frombits(readUint32())), 'f', -1, 32)
+    }
+
+    formatChecksum := func(checksum uint32) string {
+        if name, found := checksumTable[checksum]; found {
+            return fmt.Sprintf("0x%08x %s", checksum, name)
+        }
+        return fmt.Sprintf("0x%08x", checksum)
+    }
+
+    switch instruction.Opcode {
+    case Byte_NewLineWithNumber:
+        if err := need(4); err != nil {
+            return 0, err
+        }
+        instruction.Operand = fmt.Sprintf("line %d", readUint32())
+    case Byte_Checksum:
+        if err := need(4); err != nil {
+            return 0, err
+        }
+        instruction.Operand = formatChecksum(readUint32())
+    case Byte_Integer:
+        if err := need(4); err != nil {
+            return 0, err
+        }
+        instruction.Operand = strconv.Itoa(int(int32(readUint32())))
+    case Byte_Float:
+        if err := need(4); err != nil {
+            return 0, err
+        }
+        instruction.Operand = readFloat()
+    case Byte_Pair:
+        if err := need(8); err != nil {
+            return 0, err
+        }
+        instruction.Operand = fmt.Sprintf("(%s, %s)", readFloat(), readFloat())
+    case Byte_Vector:
+        if err := need(12); err != nil {
+            return 0, err
+        }
+        instruction.Operand = fmt.Sprintf("(%s, %s, %s)", readFloat(), readFloat(), readFloat())
+    case Byte_String, Byte_LocalString:
+        if err := need(4); err != nil {
+            return 0, err
+        }
+        length := int(readUint32())
+        if err := need(length); err != nil {
+            return 0, err
+        }
+        stringBytes := qb[index : index+length]
+        index += length
+        if length > 0 && stringBytes[length-1] == 0 {
+            stringBytes = stringBytes[:length-1]
+        }
+        instruction.Operand = strconv.Quote(string(stringBytes))
+    case Byte_If2, Byte_Else2:
+        if err := need(2); err != nil {
+            return 0, err
+        }
+        // offset is relative to the start of the operand
+        offset := int(binary.LittleEndian.Uint16(qb[index : index+2]))
+        instruction.JumpTargets = []int{index + offset}
+        index += 2
+        instruction.Operand = fmt.Sprintf("+%d", offset)
+    case Byte_LongJump:
+        if err := need(4); err != nil {
+            return 0, err
+        }
+        // offset is relative to the end of the operand
+        offset := int(int32(readUint32()))
+        instruction.JumpTargets = []int{index + offset}
+        instruction.Operand = fmt.Sprintf("%+d", offset)
+    case Byte_Random, Byte_RandomNoRepeat:
+        if err := need(4); err != nil {
+            return 0, err
+        }
+        numberOfBranches := int(readUint32())
+        if numberOfBranches > (len(qb)-index)/6 {
+            return 0, errors.New(fmt.Sprintf("%s at offset 0x%x has an invalid number of branches (%d)", instruction.Name, instruction.Offset, numberOfBranches))
+        }
+
+        weights := make([]string, numberOfBranches)
+        for i := range weights {
+            weights[i] = strconv.Itoa(int(binary.LittleEndian.Uint16(qb[index : index+2])))
+            index += 2
+        }
+
+        // each branch offset is relative to the end of its own entry
+        for i := 0; i < numberOfBranches; i++ {
+            offset := int(readUint32())
+            instruction.JumpTargets = append(instruction.JumpTargets, index+offset)
+        }
+        instruction.Operand = fmt.Sprintf("%d branches, weights %s", numberOfBranches, strings.Join(weights, "/"))
+    case Byte_ChecksumEntry:
+        if err := need(4); err != nil {
+            return 0, err
+        }
+        checksum := readUint32()
+        nameStart := index
+        for {
+            if err := need(1); err != nil {
+                return 0, err
+            }
+            index++
+            if qb[index-1] == 0 {
+                break
+            }
+        }
+        instruction.Operand = fmt.Sprintf("0x%08x %s", checksum, strconv.Quote(string(qb[nameStart:index-1])))
+    }
+
+    return index - instruction.Offset, nil
+}
+
+// Lists one instruction per line: offset, raw bytes, opcode name, operand and jump targets.
+func FormatDisassembly(instructions []Instruction) string {
+    const maxBytesShown = 8
+
+    var output strings.Builder
+    for _, instruction := range instructions {
+        hexBytes := make([]string, 0, maxBytesShown)
+        for i, b := range instruction.Bytes {
+            if i >= maxBytesShown {
+                hexBytes = append(hexBytes, "..")
+                break
+            }
+            hexBytes = append(hexBytes, fmt.Sprintf("%02x", b))
+        }
+
+        line := fmt.Sprintf("%08x  %-26s %-18s %s", instruction.Offset, strings.Join(hexBytes, " "), instruction.Name, instruction.Operand)
+        if len(instruction.JumpTargets) > 0 {
+            targets := make([]string, len(instruction.JumpTargets))
+            for i, target := range instruction.JumpTargets {
+                targets[i] = fmt.Sprintf("%08x", target)
+            }
+            line += " -> " + strings.Join(targets, ", ")
+        }
+        output.WriteString(strings.TrimRight(line, " ") + "\n")
+    }
+    return output.String()
+}
@@ -0,0 +1,61 @@
+package decompiler
+
+import (
+    "github.com/byxor/NeverScript/compiler"
+    "strings"
+    "testing"
+)
+
+func TestFormatDisassembly(t *testing.T) {
+    // The compiler writes its checksum table in any order, so this one is added by hand
+    sourceCode := "script Foo {\n    if <x> {\n        Bar \"hi\"\n    } else {\n        Baz (1.0, 2.5)\n    }\n}\n"
+    qb, err := compileForTestWithSettings(sourceCode, compiler.BytecodeCompiler{TargetGame: "thug2", RemoveChecksums: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    qb = append(qb[:len(qb)-1],
+        Byte_ChecksumEntry, 0xde, 0x9a, 0x8c, 0x73, 'F', 'o', 'o', 0x00,
+        Byte_ChecksumEntry, 0x7c, 0xe9, 0x23, 0x73, 'x', 0x00,
+        Byte_EndOfFile,
+    )
+    instructions, err := Disassemble(qb)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    expectedListing := strings.TrimLeft(`
+00000000  01                         NewLine
+00000001  23                         Script
+00000002  16 de 9a 8c 73             Checksum           0x738c9ade Foo
+00000007  01                         NewLine
+00000008  47 1a 00                   If2                +26 -> 00000023
+0000000b  2d                         Local
+0000000c  16 7c e9 23 73             Checksum           0x7323e97c x
+00000011  01                         NewLine
+00000012  16 55 73 00 89             Checksum           0x89007355
+00000017  1b 03 00 00 00 68 69 00    String             "hi"
+0000001f  01                         NewLine
+00000020  48 13 00                   Else2              +19 -> 00000034
+00000023  01                         NewLine
+00000024  16 67 fb db 87             Checksum           0x87dbfb67
+00000029  1f 00 00 80 3f 00 00 20 .. Pair               (1, 2.5)
+00000032  01                         NewLine
+00000033  28                         EndIf
+00000034  01                         NewLine
+00000035  24                         EndScript
+00000036  01                         NewLine
+00000037  2b de 9a 8c 73 46 6f 6f .. ChecksumEntry      0x738c9ade "Foo"
+00000040  2b 7c e9 23 73 78 00       ChecksumEntry      0x7323e97c "x"
+00000047  00                         EndOfFile
+`, "\n")
+    if listing := FormatDisassembly(instructions); listing != expectedListing {
+        t.Fatalf("Expected:\n%s\nGot:\n%s", expectedListing, listing)
+    }
+}
+
+func TestDisassembleTruncatedOperand(t *testing.T) {
+    _, err := Disassemble([]byte{Byte_NewLine, Byte_Integer, 0x01})
+    if err == nil || err.Error() != "Unexpected end of QB - Integer at offset 0x1 needs 3 more bytes" {
+        t.Fatalf("Expected an error about the truncated integer, got: %v", err)
+    }
+}
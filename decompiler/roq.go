@@ -0,0 +1,237 @@
+package decompiler
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+)
+
+// Renders QB in the textual format of the roq decompiler (`roq.exe -d`), so we can get a second opinion of
+// compiled code without needing roq.exe (which only runs on Windows).
+//
+// This follows roq's quirks where they're known, e.g. the indentation it uses around random/select blocks.
+func DecompileRoq(qb []byte) (string, error) {
+    instructions, err := Disassemble(qb)
+    if err != nil {
+        return "", err
+    }
+    checksumTable := ReadChecksumTable(qb)
+
+    // roq numbers each jump target of random/select blocks in the order they're first referenced.
+    // Jumps made by if/else aren't shown.
+    labels := make(map[int]int)
+    isEndOfRandom := make(map[int]bool)
+    for _, instruction := range instructions {
+        if instruction.Opcode != Byte_Random && instruction.Opcode != Byte_RandomNoRepeat && instruction.Opcode != Byte_LongJump {
+            continue
+        }
+        for _, target := range instruction.JumpTargets {
+            if _, found := labels[target]; !found {
+                labels[target] = len(labels)
+            }
+            if instruction.Opcode == Byte_LongJump {
+                isEndOfRandom[target] = true
+            }
+        }
+    }
+
+    isClosing := func(opcode byte) bool {
+        switch opcode {
+        case Byte_EndScript, Byte_EndIf, Byte_EndWhile, Byte_EndStruct, Byte_EndArray, Byte_EndSwitch, Byte_Else, Byte_Else2:
+            return true
+        }
+        return false
+    }
+
+    var output strings.Builder
+    indentation := 0
+    dedentedByNewLine := false
+    dedentAfterRandom := false
+
+    tabs := func() string {
+        if indentation < 0 {
+            return ""
+        }
+        return strings.Repeat("\t", indentation)
+    }
+
+    uint32At := func(instruction Instruction, index int) uint32 {
+        return binary.LittleEndian.Uint32(instruction.Bytes[index : index+4])
+    }
+
+    floatAt := func(instruction Instruction, index int) string {
+        return fmt.Sprintf("%f", math.Float32frombits(uint32At(instruction, index)))
+    }
+
+    for i, instruction := range instructions {
+        if instruction.Opcode == Byte_ChecksumEntry {
+            // roq doesn't show the name table
+            continue
+        } else if instruction.Opcode == Byte_EndOfFile {
+            output.WriteString(":end")
+            break
+        }
+
+        if label, found := labels[instruction.Offset]; found {
+            output.WriteString(fmt.Sprintf(" :POS(%d) ", label))
+            if isEndOfRandom[instruction.Offset] {
+                dedentAfterRandom = true
+            }
+        }
+
+        if instruction.Opcode == Byte_Else || instruction.Opcode == Byte_Else2 {
+            // else closes the body of the if and opens its own, so it only needs to re-indent when it starts a line
+            if dedentedByNewLine {
+                indentation++
+            }
+        } else if isClosing(instruction.Opcode) && !dedentedByNewLine {
+            indentation--
+        }
+        dedentedByNewLine = false
+
+        switch instruction.Opcode {
+        case Byte_NewLine, Byte_NewLineWithNumber:
+            nextIsClosing := i+1 < len(instructions) && isClosing(instructions[i+1].Opcode)
+            if dedentAfterRandom || nextIsClosing {
+                indentation--
+                dedentedByNewLine = nextIsClosing
+                dedentAfterRandom = false
+            }
+            if output.Len() > 0 {
+                output.WriteString("\n")
+            }
+            output.WriteString(tabs() + ":i ")
+        case Byte_Struct:
+            output.WriteString(":s{")
+            indentation++
+        case Byte_EndStruct:
+            output.WriteString(":s}")
+        case Byte_Array:
+            output.WriteString(":a{")
+            indentation++
+        case Byte_EndArray:
+            output.WriteString(":a}")
+        case Byte_Equals, Byte_EqualTo:
+            output.WriteString(" = ")
+        case Byte_Dot:
+            output.WriteString(".")
+        case Byte_Comma:
+            output.WriteString(";")
+        case Byte_Minus:
+            output.WriteString(" - ")
+        case Byte_Plus:
+            output.WriteString(" + ")
+        case Byte_Divide:
+            output.WriteString(" / ")
+        case Byte_Multiply:
+            output.WriteString(" * ")
+        case Byte_Parenthesis:
+            output.WriteString(" (")
+        case Byte_EndParenthesis:
+            output.WriteString(") ")
+        case Byte_LessThan:
+            output.WriteString(" < ")
+        case Byte_LessThanEqual:
+            output.WriteString(" <= ")
+        case Byte_GreaterThan:
+            output.WriteString(" > ")
+        case Byte_GreaterThanEqual:
+            output.WriteString(" >= ")
+        case Byte_Checksum:
+            checksum := uint32At(instruction, 1)
+            if name, found := checksumTable[checksum]; found {
+                output.WriteString(fmt.Sprintf("$%s$", name))
+            } else {
+                output.WriteString(fmt.Sprintf("$[%08x]$", checksum))
+            }
+        case Byte_Integer:
+            integer := uint32At(instruction, 1)
+            output.WriteString(fmt.Sprintf("%%i(%d,%08x)", integer, integer))
+        case Byte_Float:
+            output.WriteString(fmt.Sprintf("%%f(%s)", floatAt(instruction, 1)))
+        case Byte_String, Byte_LocalString:
+            format := "%%s(%d,\"%s\")"
+            if instruction.Opcode == Byte_LocalString {
+                format = "%%sc(%d,\"%s\")"
+            }
+            text := strings.TrimSuffix(string(instruction.Bytes[5:]), "\x00")
+            output.WriteString(fmt.Sprintf(format, len(text), text))
+        case Byte_Pair:
+            output.WriteString(fmt.Sprintf("%%vec2(%s,%s)", floatAt(instruction, 1), floatAt(instruction, 5)))
+        case Byte_Vector:
+            output.WriteString(fmt.Sprintf("%%vec3(%s,%s,%s)", floatAt(instruction, 1), floatAt(instruction, 5), floatAt(instruction, 9)))
+        case Byte_While:
+            indentation++
+            output.WriteString("while\n" + tabs())
+        case Byte_EndWhile:
+            output.WriteString("loop_to")
+        case Byte_Break:
+            output.WriteString("continue\n" + tabs())
+        case Byte_Script:
+            output.WriteString("function ")
+            indentation++
+        case Byte_EndScript:
+            output.WriteString("endfunction")
+        case Byte_If, Byte_If2:
+            output.WriteString("if ")
+            indentation++
+        case Byte_Else:
+            output.WriteString("else")
+        case Byte_Else2:
+            output.WriteString("else ")
+        case Byte_EndIf:
+            output.WriteString("endif")
+        case Byte_Return:
+            output.WriteString("return\n" + tabs())
+        case Byte_AllArguments:
+            output.WriteString("<...>")
+        case Byte_Local:
+            output.WriteString("%GLOBAL%")
+        case Byte_LongJump:
+            indentation--
+            breakTo := fmt.Sprintf("\n%s:BREAKTO(%d)\n", tabs(), labels[instruction.JumpTargets[0]])
+            indentation++
+            output.WriteString(breakTo + tabs())
+        case Byte_Random, Byte_RandomNoRepeat:
+            numberOfBranches := len(instruction.JumpTargets)
+            weights := make([]string, 2*numberOfBranches)
+            for j := range weights {
+                weights[j] = fmt.Sprintf("%02x", instruction.Bytes[5+j])
+            }
+            output.WriteString(fmt.Sprintf("select(%02x,%d, %s) ", instruction.Opcode, numberOfBranches, strings.Join(weights, " ")))
+            for _, target := range instruction.JumpTargets {
+                output.WriteString(fmt.Sprintf(":OFFSET(%d)", labels[target]))
+            }
+            indentation++
+            output.WriteString("\n" + tabs())
+        case Byte_RandomRange:
+            output.WriteString("RandomRange")
+        case Byte_Or:
+            output.WriteString(" OR ")
+        case Byte_And:
+            output.WriteString(" AND ")
+        case Byte_Xor:
+            output.WriteString(" XOR ")
+        case Byte_Not:
+            output.WriteString("NOT ")
+        case Byte_Switch:
+            output.WriteString("switch ")
+            indentation++
+        case Byte_EndSwitch:
+            output.WriteString("endswitch")
+        case Byte_Case:
+            output.WriteString("case ")
+        case Byte_Default:
+            output.WriteString("default ")
+        case Byte_Colon:
+            // roq shows member function calls like `Object:GetCollision` with a dot
+            output.WriteString(".")
+        default:
+            return output.String(), errors.New(fmt.Sprintf("Can't show byte 0x%02x at offset 0x%x in roq format", instruction.Opcode, instruction.Offset))
+        }
+    }
+
+    return output.String(), nil
+}
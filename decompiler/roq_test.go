@@ -0,0 +1,60 @@
+package decompiler
+
+import (
+    "testing"
+)
+
+func TestDecompileRoq(t *testing.T) {
+    sourceCode := "x = 10\nscript Foo {\n    if <x> {\n        Bar text=\"hi\"\n    } else {\n        Baz value=(1.0, 2.5)\n    }\n    while {\n        Wait 1 gameframe\n        break\n    }\n}\n"
+    qb, err := compileForTest(sourceCode, "thps4")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    roq, err := DecompileRoq(qb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedRoq := ":i $x$ = %i(10,0000000a)\n" +
+        ":i function $Foo$\n" +
+        "\t:i if %GLOBAL%$x$\n" +
+        "\t\t:i $Bar$$text$ = %s(2,\"hi\")\n" +
+        "\t:i else\n" +
+        "\t\t:i $Baz$$value$ = %vec2(1.000000,2.500000)\n" +
+        "\t:i endif\n" +
+        "\t:i while\n" +
+        "\t\t\n" +
+        "\t\t:i $Wait$%i(1,00000001)$gameframe$\n" +
+        "\t\t:i continue\n" +
+        "\t\t\n" +
+        "\t:i loop_to\n" +
+        ":i endfunction\n" +
+        ":i :end"
+    if roq != expectedRoq {
+        t.Fatalf("Expected:\n%q\nGot:\n%q", expectedRoq, roq)
+    }
+}
+
+func TestDecompileRoqMemberFunction(t *testing.T) {
+    qb, err := compileForTest("script Foo {\n    Object:Die\n}\n", "thps4")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Like roq, with a dot
+    roq, err := DecompileRoq(qb)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedRoq := ":i function $Foo$\n\t:i $Object$.$Die$\n:i endfunction\n:i :end"
+    if roq != expectedRoq {
+        t.Fatalf("Expected:\n%q\nGot:\n%q", expectedRoq, roq)
+    }
+}
+
+func TestDecompileRoqUnknownByte(t *testing.T) {
+    _, err := DecompileRoq([]byte{Byte_NewLine, 0x99, Byte_EndOfFile})
+    if err == nil || err.Error() != "Can't show byte 0x99 at offset 0x1 in roq format" {
+        t.Fatalf("Expected an error about the 0x99 byte, got: %v", err)
+    }
+}
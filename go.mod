@@ -1,5 +1,5 @@
 module github.com/byxor/NeverScript
 
-go 1.15
+go 1.18
 
 require github.com/pmezard/go-difflib v1.0.0
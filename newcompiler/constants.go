@@ -0,0 +1,538 @@
+package newcompiler
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// Constants are declared outside of scripts and substituted wherever their name is used:
+// --------------------------------------------------
+//
+// `const FRAMES_PER_SECOND = 60`
+// `const DELAY = (2 * FRAMES_PER_SECOND)`
+// `script Foo { wait DELAY frames }`      -->  `script Foo { wait 120 frames }`
+//
+// Arithmetic on literal ints, floats, pairs and vectors is folded at the same time, whether or not it uses constants:
+// --------------------------------------------------
+//
+// `x = (2 * 60)`                          -->  `x = 120`
+//
+// `offset = ((1, 2, 3) * 2)`              -->  `offset = (2.0, 4.0, 6.0)`
+//
+// Operations are parsed right-to-left without precedence (the game applies it when the script runs), so an operation
+// that's the right-hand side of another operation isn't folded on its own, e.g. the `2 - 3` in `a - 2 - 3`.
+//
+// Members of enums (see enums.go) are substituted in the same pass.
+//
+type constantResolver struct {
+    declarations map[string]wrappedNodes // By lowercase name
+    order        []string
+    enums        map[string]enum // By lowercase name
+    values       map[string]Node // The value of each constant once resolved
+    inProgress   []string        // The constants being resolved, for reporting cycles
+}
+
+func newConstantResolver() *constantResolver {
+    return &constantResolver{
+        declarations: map[string]wrappedNodes{},
+        enums:        map[string]enum{},
+        values:       map[string]Node{},
+    }
+}
+
+func resolveConstants(program Node) (Node, error) {
+    return newConstantResolver().resolveConstants(program)
+}
+
+// The declarations are kept, so a program resolved later (e.g. a file that includes this one) can use them.
+func (this *constantResolver) resolveConstants(program Node) (Node, error) {
+    firstNewDeclaration := len(this.order)
+
+    var nodes []Node
+    for _, node := range program.(wrappedNodes).nodes {
+        switch node.Kind() {
+        case NodeKind_Const:
+            name := constantNameOf(node.(wrappedNodes))
+            if err := this.checkNameIsUnused(name); err != nil {
+                return nil, err
+            }
+            key := strings.ToLower(name.data)
+            this.declarations[key] = node.(wrappedNodes)
+            this.order = append(this.order, key)
+        case NodeKind_Enum:
+            enum_, err := newEnum(node.(manyWrappedNodes))
+            if err != nil {
+                return nil, err
+            }
+            if err := this.checkNameIsUnused(enum_.name); err != nil {
+                return nil, err
+            }
+            this.enums[strings.ToLower(enum_.name.data)] = enum_
+        default:
+            nodes = append(nodes, node)
+        }
+    }
+
+    // Constants are resolved before they're used so the errors about them come first
+    for _, key := range this.order[firstNewDeclaration:] {
+        if _, err := this.resolveConstant(key); err != nil {
+            return nil, err
+        }
+    }
+
+    nodes, err := this.resolveNodes(nodes)
+    if err != nil {
+        return nil, err
+    }
+
+    return wrappedNodes{
+        kind:                NodeKind_Program,
+        nodes:               nodes,
+        extraTokensConsumed: program.(wrappedNodes).extraTokensConsumed,
+    }, nil
+}
+
+func (this *constantResolver) checkNameIsUnused(name basicNode) error {
+    key := strings.ToLower(name.data)
+    if existingDeclaration, found := this.declarations[key]; found {
+        existingName := constantNameOf(existingDeclaration)
+        return errors.New(fmt.Sprintf("'%s' on line %d is already defined (as const '%s' on line %d)", name.data, name.lineNumber, existingName.data, existingName.lineNumber))
+    }
+    if existingEnum, found := this.enums[key]; found {
+        return errors.New(fmt.Sprintf("'%s' on line %d is already defined (as enum '%s' on line %d)", name.data, name.lineNumber, existingEnum.name.data, existingEnum.name.lineNumber))
+    }
+    return nil
+}
+
+func (this *constantResolver) resolveConstant(key string) (Node, error) {
+    if value, found := this.values[key]; found {
+        return value, nil
+    }
+
+    for i, name := range this.inProgress {
+        if name == key {
+            var cycle []string
+            for _, nameInCycle := range this.inProgress[i:] {
+                cycle = append(cycle, constantNameOf(this.declarations[nameInCycle]).data)
+            }
+            name := constantNameOf(this.declarations[key])
+            cycle = append(cycle, name.data)
+            return nil, errors.New(fmt.Sprintf("const '%s' on line %d depends on itself: %s", name.data, name.lineNumber, strings.Join(cycle, " -> ")))
+        }
+    }
+    this.inProgress = append(this.inProgress, key)
+    defer func() {
+        this.inProgress = this.inProgress[:len(this.inProgress)-1]
+    }()
+
+    declaration := this.declarations[key]
+    value, err := this.resolve(declaration.nodes[1], nil)
+    if err != nil {
+        return nil, err
+    }
+    if !isLiteral(value) {
+        name := constantNameOf(declaration)
+        return nil, errors.New(fmt.Sprintf("const '%s' on line %d must be a value that's known when compiling (e.g. a number, string, checksum, pair or vector)", name.data, name.lineNumber))
+    }
+
+    this.values[key] = value
+    return value, nil
+}
+
+func (this *constantResolver) resolveNodes(nodes []Node) ([]Node, error) {
+    var resolvedNodes []Node
+    for _, node := range nodes {
+        // Only the assignments at the root of the file are globals, the rest are parameters and struct members
+        if node.Kind() == NodeKind_AssignmentOperation {
+            name := assignmentOperands(node)[0]
+            if constantName, isConstant := this.constantName(name); isConstant {
+                return nil, errors.New(fmt.Sprintf("Can't assign to const '%s' on line %d", constantName, name.LineNumber()))
+            }
+        }
+
+        resolvedNode, err := this.resolve(node, nil)
+        if err != nil {
+            return nil, err
+        }
+        resolvedNodes = append(resolvedNodes, resolvedNode)
+    }
+    return resolvedNodes, nil
+}
+
+// Substitutes constants and folds operations in a node and everything inside it.
+// The parent is the node it's inside of, which decides whether it can be folded (see isFoldableIn).
+func (this *constantResolver) resolve(node Node, parent Node) (Node, error) {
+    if node == nil {
+        return nil, nil
+    }
+
+    switch node.Kind() {
+    case NodeKind_Const:
+        name := constantNameOf(node.(wrappedNodes))
+        return nil, errors.New(fmt.Sprintf("const '%s' on line %d must be declared outside of scripts and other blocks", name.data, name.lineNumber))
+    case NodeKind_Enum:
+        name := node.(manyWrappedNodes).nodeLists[0][0].(basicNode)
+        return nil, errors.New(fmt.Sprintf("enum '%s' on line %d must be declared outside of scripts and other blocks", name.data, name.lineNumber))
+    case NodeKind_QbKey:
+        return this.substitute(node)
+    case NodeKind_LocalQbKey:
+        // `<speed>` is always the local variable, even if there's a constant called `speed`
+        return node, nil
+    case NodeKind_Script, NodeKind_InlineScript:
+        return this.resolveScript(node.(manyWrappedNodes))
+    case NodeKind_AssignmentOperation, NodeKind_DotOperation:
+        return this.resolveNamedOperation(node)
+    }
+
+    resolvedNode, err := rebuildChildren(node, func(child Node) (Node, error) {
+        return this.resolve(child, node)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // Brackets and negation are folded wherever they are, operations only where they can't be grouped differently
+    if _, isWrappedNode := resolvedNode.(wrappedNode); isWrappedNode || isFoldableIn(parent) {
+        return fold(resolvedNode), nil
+    }
+    return resolvedNode, nil
+}
+
+// The script's name isn't a use of a constant.
+func (this *constantResolver) resolveScript(script manyWrappedNodes) (Node, error) {
+    name := script.nodeLists[0]
+    script.nodeLists = append([][]Node{nil}, script.nodeLists[1:]...)
+    resolvedScript, err := rebuildChildren(script, func(child Node) (Node, error) {
+        return this.resolve(child, script)
+    })
+    if err != nil {
+        return nil, err
+    }
+    script = resolvedScript.(manyWrappedNodes)
+    script.nodeLists[0] = name
+    return script, nil
+}
+
+// The name in an assignment (`speed = 10`) or after a dot (`<options>.speed`) isn't a use of a constant, but the other
+// side is.
+func (this *constantResolver) resolveNamedOperation(node Node) (Node, error) {
+    fixedSize, isFixedSize := node.(fixedSizeWrappedNode)
+    operation := fixedSize.node
+    if !isFixedSize {
+        operation = node.(manyWrappedNodes)
+    }
+    operands := operation.nodeLists[0]
+
+    nameIndex := 0
+    if operation.kind == NodeKind_DotOperation {
+        nameIndex = 1
+
+        // A member of an enum, e.g. `TrickType.Manual`
+        if leftHandSide := operands[0]; leftHandSide.Kind() == NodeKind_QbKey {
+            if enum_, isEnum := this.enums[strings.ToLower(leftHandSide.(basicNode).data)]; isEnum {
+                // `TrickType.Manual == x` is parsed as `TrickType.(Manual == x)`
+                resolvedNode, err := withLeftmostOperand(operands[1], func(memberNode Node) (Node, error) {
+                    return enum_.member(leftHandSide.(basicNode), memberNode)
+                })
+                if err != nil {
+                    return nil, err
+                }
+                return this.resolve(resolvedNode, nil)
+            }
+        }
+    }
+
+    resolvedOperands := make([]Node, len(operands))
+    copy(resolvedOperands, operands)
+    valueIndex := 1 - nameIndex
+    value, err := this.resolve(operands[valueIndex], operation)
+    if err != nil {
+        return nil, err
+    }
+    resolvedOperands[valueIndex] = value
+    operation.nodeLists = append([][]Node{resolvedOperands}, operation.nodeLists[1:]...)
+
+    if isFixedSize {
+        fixedSize.node = operation
+        return fixedSize, nil
+    }
+    return operation, nil
+}
+
+func assignmentOperands(node Node) []Node {
+    if fixedSize, ok := node.(fixedSizeWrappedNode); ok {
+        return fixedSize.node.nodeLists[0]
+    }
+    return node.(manyWrappedNodes).nodeLists[0]
+}
+
+func constantNameOf(declaration wrappedNodes) basicNode {
+    return declaration.nodes[0].(basicNode)
+}
+
+func (this *constantResolver) constantName(node Node) (string, bool) {
+    if node == nil || node.Kind() != NodeKind_QbKey {
+        return "", false
+    }
+    name := node.(basicNode).data
+    _, isConstant := this.declarations[strings.ToLower(name)]
+    return name, isConstant
+}
+
+func (this *constantResolver) substitute(node Node) (Node, error) {
+    name, isConstant := this.constantName(node)
+    if !isConstant {
+        return node, nil
+    }
+    value, err := this.resolveConstant(strings.ToLower(name))
+    if err != nil {
+        return nil, err
+    }
+    if basicValue, ok := value.(basicNode); ok {
+        // Keep the position of the name so line numbers stay right
+        basicValue.tokensConsumed = node.TokensConsumed()
+        basicValue.lineNumber = node.LineNumber()
+        return basicValue, nil
+    }
+    return value, nil
+}
+
+// Replaces the operand furthest to the left in a chain of operations, e.g. `a` in `a + b * c`.
+func withLeftmostOperand(node Node, replace func(Node) (Node, error)) (Node, error) {
+    switch operation := node.(type) {
+    case manyWrappedNodes:
+        if isBinaryOperation(operation.kind) {
+            leftHandSide, err := withLeftmostOperand(operation.nodeLists[0][0], replace)
+            if err != nil {
+                return nil, err
+            }
+            operation.nodeLists = append([][]Node{{leftHandSide, operation.nodeLists[0][1]}}, operation.nodeLists[1:]...)
+            return operation, nil
+        }
+    case fixedSizeWrappedNode:
+        if isBinaryOperation(operation.node.kind) {
+            resolvedNode, err := withLeftmostOperand(operation.node, replace)
+            if err != nil {
+                return nil, err
+            }
+            operation.node = resolvedNode.(manyWrappedNodes)
+            return operation, nil
+        }
+    }
+    return replace(node)
+}
+
+func isBinaryOperation(kind NodeKind) bool {
+    switch kind {
+    case NodeKind_PlusOperation, NodeKind_MinusOperation, NodeKind_MultiplyOperation, NodeKind_DivideOperation,
+        NodeKind_AssignmentOperation, NodeKind_EqualityOperation, NodeKind_InequalityOperation,
+        NodeKind_GreaterThanOperation, NodeKind_LessThanOperation, NodeKind_GreaterThanEqualOperation,
+        NodeKind_LessThanEqualOperation, NodeKind_AndOperation, NodeKind_OrOperation, NodeKind_ColonOperation,
+        NodeKind_DotOperation:
+        return true
+    }
+    return false
+}
+
+// An operation can't be folded on its own when it's the right-hand side of another operation (or negated), because
+// the game might group it differently, e.g. `a * 2 + 3` is parsed as `a * (2 + 3)` but runs as `(a * 2) + 3`.
+func isFoldableIn(parent Node) bool {
+    if parent == nil {
+        return true
+    }
+    if _, isWrappedNode := parent.(wrappedNode); isWrappedNode {
+        return parent.Kind() == NodeKind_ParenthesisOperation
+    }
+    return parent.Kind() == NodeKind_AssignmentOperation || !isBinaryOperation(parent.Kind())
+}
+
+func isLiteral(node Node) bool {
+    switch node.Kind() {
+    case NodeKind_Int, NodeKind_Float, NodeKind_String, NodeKind_QbKey, NodeKind_RawQbKey:
+        return true
+    case NodeKind_Pair, NodeKind_Vector:
+        for _, component := range node.(wrappedNodes).nodes {
+            if !isNumber(component) {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+func isNumber(node Node) bool {
+    return node != nil && (node.Kind() == NodeKind_Int || node.Kind() == NodeKind_Float)
+}
+
+func isPairOrVector(node Node) bool {
+    return node != nil && (node.Kind() == NodeKind_Pair || node.Kind() == NodeKind_Vector) && isLiteral(node)
+}
+
+// Folds an operation on literals into a literal. Anything else is returned as it is.
+func fold(node Node) Node {
+    switch node.Kind() {
+    case NodeKind_ParenthesisOperation:
+        if inner := node.(wrappedNode).node; isNumber(inner) || isPairOrVector(inner) {
+            return inner
+        }
+    case NodeKind_UnaryMinusOperation:
+        inner := node.(wrappedNode).node
+        if isNumber(inner) {
+            return negate(inner.(basicNode))
+        } else if isPairOrVector(inner) {
+            components := inner.(wrappedNodes)
+            negatedComponents := make([]Node, len(components.nodes))
+            for i, component := range components.nodes {
+                negatedComponents[i] = negate(component.(basicNode))
+            }
+            components.nodes = negatedComponents
+            return components
+        }
+    case NodeKind_PlusOperation, NodeKind_MinusOperation, NodeKind_MultiplyOperation, NodeKind_DivideOperation:
+        operation := node.(manyWrappedNodes)
+        leftHandSide := operation.nodeLists[0][0]
+        rightHandSide := operation.nodeLists[0][1]
+        if folded := foldArithmetic(operation.kind, leftHandSide, rightHandSide); folded != nil {
+            return folded
+        }
+    }
+    return node
+}
+
+func foldArithmetic(kind NodeKind, leftHandSide Node, rightHandSide Node) Node {
+    if isNumber(leftHandSide) && isNumber(rightHandSide) {
+        return foldNumbers(kind, leftHandSide.(basicNode), rightHandSide.(basicNode))
+    }
+
+    // (1, 2) + (3, 4), (1, 2, 3) - (4, 5, 6)
+    if isPairOrVector(leftHandSide) && isPairOrVector(rightHandSide) && leftHandSide.Kind() == rightHandSide.Kind() {
+        if kind != NodeKind_PlusOperation && kind != NodeKind_MinusOperation {
+            return nil
+        }
+        left := leftHandSide.(wrappedNodes)
+        right := rightHandSide.(wrappedNodes)
+        return foldComponents(left, func(i int, component basicNode) Node {
+            return foldNumbers(kind, asFloat(component), asFloat(right.nodes[i].(basicNode)))
+        })
+    }
+
+    // (1, 2, 3) * 2, (1, 2, 3) / 2
+    if isPairOrVector(leftHandSide) && isNumber(rightHandSide) && (kind == NodeKind_MultiplyOperation || kind == NodeKind_DivideOperation) {
+        return foldComponents(leftHandSide.(wrappedNodes), func(i int, component basicNode) Node {
+            return foldNumbers(kind, asFloat(component), asFloat(rightHandSide.(basicNode)))
+        })
+    }
+
+    // 2 * (1, 2, 3)
+    if isNumber(leftHandSide) && isPairOrVector(rightHandSide) && kind == NodeKind_MultiplyOperation {
+        return foldComponents(rightHandSide.(wrappedNodes), func(i int, component basicNode) Node {
+            return foldNumbers(kind, asFloat(leftHandSide.(basicNode)), asFloat(component))
+        })
+    }
+
+    return nil
+}
+
+func foldComponents(node wrappedNodes, foldComponent func(int, basicNode) Node) Node {
+    components := make([]Node, len(node.nodes))
+    for i, component := range node.nodes {
+        components[i] = foldComponent(i, component.(basicNode))
+        if components[i] == nil {
+            return nil
+        }
+    }
+    node.nodes = components
+    return node
+}
+
+func foldNumbers(kind NodeKind, leftHandSide basicNode, rightHandSide basicNode) Node {
+    result := basicNode{
+        kind:           NodeKind_Float,
+        tokensConsumed: leftHandSide.tokensConsumed + rightHandSide.tokensConsumed + 1,
+        lineNumber:     leftHandSide.lineNumber,
+    }
+
+    if leftHandSide.kind == NodeKind_Int && rightHandSide.kind == NodeKind_Int {
+        left, leftErr := strconv.ParseInt(leftHandSide.data, 10, 32)
+        right, rightErr := strconv.ParseInt(rightHandSide.data, 10, 32)
+        if leftErr != nil || rightErr != nil {
+            return nil
+        }
+        var value int32
+        switch kind {
+        case NodeKind_PlusOperation:
+            value = int32(left) + int32(right)
+        case NodeKind_MinusOperation:
+            value = int32(left) - int32(right)
+        case NodeKind_MultiplyOperation:
+            value = int32(left) * int32(right)
+        case NodeKind_DivideOperation:
+            // Only exact divisions, the game might not round the same way
+            if right == 0 || left%right != 0 {
+                return nil
+            }
+            value = int32(left / right)
+        }
+        result.kind = NodeKind_Int
+        result.data = strconv.FormatInt(int64(value), 10)
+        return result
+    }
+
+    left, leftErr := strconv.ParseFloat(leftHandSide.data, 32)
+    right, rightErr := strconv.ParseFloat(rightHandSide.data, 32)
+    if leftErr != nil || rightErr != nil {
+        return nil
+    }
+    var value float32
+    switch kind {
+    case NodeKind_PlusOperation:
+        value = float32(left) + float32(right)
+    case NodeKind_MinusOperation:
+        value = float32(left) - float32(right)
+    case NodeKind_MultiplyOperation:
+        value = float32(left) * float32(right)
+    case NodeKind_DivideOperation:
+        if right == 0 {
+            return nil
+        }
+        value = float32(left) / float32(right)
+    }
+    if math.IsInf(float64(value), 0) || math.IsNaN(float64(value)) {
+        return nil
+    }
+    result.data = formatFloat(value)
+    return result
+}
+
+func negate(node basicNode) basicNode {
+    if node.kind == NodeKind_Int {
+        if value, err := strconv.ParseInt(node.data, 10, 32); err == nil {
+            node.data = strconv.FormatInt(int64(-int32(value)), 10)
+            return node
+        }
+    }
+    if strings.HasPrefix(node.data, "-") {
+        node.data = node.data[1:]
+    } else {
+        node.data = "-" + node.data
+    }
+    return node
+}
+
+func asFloat(node basicNode) basicNode {
+    node.kind = NodeKind_Float
+    return node
+}
+
+// Always has a decimal point so it reads as a float.
+func formatFloat(value float32) string {
+    text := strconv.FormatFloat(float64(value), 'f', -1, 32)
+    if !strings.Contains(text, ".") {
+        text += ".0"
+    }
+    return text
+}
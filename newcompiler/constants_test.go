@@ -0,0 +1,108 @@
+package newcompiler
+
+import (
+    "bytes"
+    "testing"
+)
+
+func compileForTest(sourceCode string) ([]byte, error) {
+    tokens, err := Lex(sourceCode)
+    if err != nil {
+        return nil, err
+    }
+    program, err := Parse(tokens)
+    if err != nil {
+        return nil, err
+    }
+    return ProduceQb(program)
+}
+
+// Compiles the declarations followed by each source and checks they produce the same QB.
+func expectSameQb(t *testing.T, declarations string, sourceCode string, expectedSourceCode string) {
+    t.Helper()
+    qb, err := compileForTest(declarations + sourceCode)
+    if err != nil {
+        t.Fatalf("Failed to compile:\n%s%s\n%s", declarations, sourceCode, err)
+    }
+    expectedQb, err := compileForTest(declarations + expectedSourceCode)
+    if err != nil {
+        t.Fatalf("Failed to compile:\n%s%s\n%s", declarations, expectedSourceCode, err)
+    }
+    if !bytes.Equal(qb, expectedQb) {
+        t.Fatalf("Compiled differently:\n%s\n% x\n\nExpected the same as:\n%s\n% x", sourceCode, qb, expectedSourceCode, expectedQb)
+    }
+}
+
+func expectCompilationError(t *testing.T, sourceCode string, expectedError string) {
+    t.Helper()
+    _, err := compileForTest(sourceCode)
+    if err == nil {
+        t.Fatalf("Expected '%s', but it compiled:\n%s", expectedError, sourceCode)
+    }
+    if err.Error() != expectedError {
+        t.Fatalf("Expected '%s', got '%s'", expectedError, err.Error())
+    }
+}
+
+func TestConstantSubstitution(t *testing.T) {
+    expectSameQb(t,
+        "const SPEED = 10\nconst NAME = \"Tony\"\nconst SPOT = (1.0, 2.0)\n",
+        "script Foo {\n    SetSpeed speed=SPEED\n    <name> = NAME\n    <spot> = SPOT\n}\n",
+        "script Foo {\n    SetSpeed speed=10\n    <name> = \"Tony\"\n    <spot> = (1.0, 2.0)\n}\n")
+
+    // Constants are case-insensitive, like checksums
+    expectSameQb(t,
+        "const Speed = 10\n",
+        "script Foo {\n    SetSpeed speed=SPEED\n}\n",
+        "script Foo {\n    SetSpeed speed=10\n}\n")
+
+    // Constants can use constants that are declared after them
+    expectSameQb(t,
+        "const DELAY = (2 * FRAMES_PER_SECOND)\nconst FRAMES_PER_SECOND = 60\n",
+        "script Foo {\n    wait DELAY frames\n}\n",
+        "script Foo {\n    wait 120 frames\n}\n")
+}
+
+func TestConstantNamesAreOnlySubstitutedWhereTheyreUsed(t *testing.T) {
+    // Locals, parameter names, struct members and members after a dot keep their name
+    expectSameQb(t,
+        "const SPEED = 10\n",
+        "script Foo {\n    <speed> = 1\n    SetSpeed speed = SPEED\n    <x> = <options>.speed\n}\n",
+        "script Foo {\n    <speed> = 1\n    SetSpeed speed = 10\n    <x> = <options>.speed\n}\n")
+    expectSameQb(t,
+        "const TIME = 3\n",
+        "x = { time = TIME }\nscript Foo {\n    Bar { time = 3 }\n}\n",
+        "x = { time = 3 }\nscript Foo {\n    Bar { time = 3 }\n}\n")
+}
+
+func TestConstantFolding(t *testing.T) {
+    expectSameQb(t, "", "x = (2 * 60)\n", "x = 120\n")
+    expectSameQb(t, "", "x = (1.5 + 1)\n", "x = 2.5\n")
+    expectSameQb(t, "", "x = -(4)\n", "x = -4\n")
+    expectSameQb(t, "", "x = ((1, 2, 3) * 2)\n", "x = (2.0, 4.0, 6.0)\n")
+
+    // The right-hand side of another operation might be grouped differently by the game, so it's left alone
+    expectSameQb(t,
+        "const A = 2\n",
+        "script Foo {\n    <x> = (<a> - A - 3)\n}\n",
+        "script Foo {\n    <x> = (<a> - 2 - 3)\n}\n")
+    expectSameQb(t,
+        "",
+        "script Foo {\n    <x> = (<a> - (2 - 3))\n}\n",
+        "script Foo {\n    <x> = (<a> - -1)\n}\n")
+}
+
+func TestConstantErrors(t *testing.T) {
+    expectCompilationError(t,
+        "const A = B\nconst B = A\n",
+        "const 'A' on line 1 depends on itself: A -> B -> A")
+    expectCompilationError(t,
+        "const SPEED = 10\nspeed = 5\n",
+        "Can't assign to const 'speed' on line 2")
+    expectCompilationError(t,
+        "const SPEED = 10\nconst Speed = 5\n",
+        "'Speed' on line 2 is already defined (as const 'SPEED' on line 1)")
+    expectCompilationError(t,
+        "script Foo {\n    const SPEED = 10\n}\n",
+        "const 'SPEED' on line 2 must be declared outside of scripts and other blocks")
+}
@@ -0,0 +1,87 @@
+package newcompiler
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Enums name a set of related values and are declared outside of scripts:
+// --------------------------------------------------
+//
+// `enum TrickType { Grind Manual Flip }`
+// `x = TrickType.Manual`                  -->  `x = Manual`
+//
+// `enum SkaterState : int { Skating, Air, Falling }`
+// `x = SkaterState.Air`                   -->  `x = 1`
+//
+// Members are checksums of their own names unless the enum's type is `int`, in which case they count up from 0.
+// Using a member that isn't declared through the enum's name (e.g. `TrickType.Grnid`) is an error.
+//
+type enum struct {
+    name    basicNode
+    members map[string]Node // By lowercase name
+}
+
+func newEnum(declaration manyWrappedNodes) (enum, error) {
+    name := declaration.nodeLists[0][0].(basicNode)
+    enum_ := enum{
+        name:    name,
+        members: map[string]Node{},
+    }
+
+    isInt := false
+    if len(declaration.nodeLists[1]) > 0 {
+        typeName := declaration.nodeLists[1][0].(basicNode)
+        switch strings.ToLower(typeName.data) {
+        case "int":
+            isInt = true
+        case "checksum":
+        default:
+            return enum{}, errors.New(fmt.Sprintf("enum '%s' on line %d has an unknown type '%s' (expected int or checksum)", name.data, name.lineNumber, typeName.data))
+        }
+    }
+
+    memberNames := map[string]string{}
+    for _, member := range declaration.nodeLists[2] {
+        if member.Kind() != NodeKind_QbKey {
+            continue
+        }
+        memberName := member.(basicNode)
+        key := strings.ToLower(memberName.data)
+        if existingName, found := memberNames[key]; found {
+            return enum{}, errors.New(fmt.Sprintf("enum '%s' already has a member called '%s' (line %d)", name.data, existingName, memberName.lineNumber))
+        }
+        memberNames[key] = memberName.data
+
+        if isInt {
+            enum_.members[key] = basicNode{
+                kind:           NodeKind_Int,
+                data:           strconv.Itoa(len(enum_.members)),
+                tokensConsumed: 1,
+                lineNumber:     memberName.lineNumber,
+            }
+        } else {
+            enum_.members[key] = memberName
+        }
+    }
+
+    return enum_, nil
+}
+
+// Finds the value of a member used through the enum's name, e.g. `TrickType.Manual`.
+func (this enum) member(enumName basicNode, memberNode Node) (Node, error) {
+    if memberNode == nil || memberNode.Kind() != NodeKind_QbKey {
+        return nil, errors.New(fmt.Sprintf("Expected a member name after '%s.' on line %d", enumName.data, enumName.lineNumber))
+    }
+    memberName := memberNode.(basicNode)
+    value, found := this.members[strings.ToLower(memberName.data)]
+    if !found {
+        return nil, errors.New(fmt.Sprintf("'%s' isn't a member of enum '%s' (line %d)", memberName.data, this.name.data, memberName.lineNumber))
+    }
+    member := value.(basicNode)
+    member.tokensConsumed = memberNode.TokensConsumed()
+    member.lineNumber = memberName.lineNumber
+    return member, nil
+}
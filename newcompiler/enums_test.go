@@ -0,0 +1,61 @@
+package newcompiler
+
+import (
+    "testing"
+)
+
+func TestEnumMembers(t *testing.T) {
+    // Checksums of their own names by default
+    expectSameQb(t,
+        "enum TrickType { Grind Manual Flip }\n",
+        "x = TrickType.Manual\n",
+        "x = Manual\n")
+    expectSameQb(t,
+        "enum TrickType : checksum { Grind, Manual, Flip }\n",
+        "x = TrickType.Flip\n",
+        "x = Flip\n")
+
+    // Counting up from 0 for ints
+    expectSameQb(t,
+        "enum SkaterState : int { Skating, Air, Falling }\n",
+        "x = SkaterState.Skating\ny = SkaterState.Falling\n",
+        "x = 0\ny = 2\n")
+}
+
+func TestEnumReferences(t *testing.T) {
+    // Members can be used in scripts, as arguments, in operations and in constants, without matching case
+    expectSameQb(t,
+        "enum SkaterState : int { Skating, Air, Falling }\nconst START = SkaterState.air\n",
+        "script Foo {\n    SetState state=skaterstate.Falling\n    if (<state> == SkaterState.Air) {\n        <x> = START\n    }\n}\n",
+        "script Foo {\n    SetState state=2\n    if (<state> == 1) {\n        <x> = 1\n    }\n}\n")
+
+    // `SkaterState.Air == <state>` is parsed as `SkaterState.(Air == <state>)`
+    expectSameQb(t,
+        "enum SkaterState : int { Skating, Air, Falling }\n",
+        "script Foo {\n    if (SkaterState.Air == <state>) {\n    }\n}\n",
+        "script Foo {\n    if (1 == <state>) {\n    }\n}\n")
+
+    // A dot after anything else is left alone
+    expectSameQb(t,
+        "enum SkaterState : int { Skating, Air, Falling }\n",
+        "x = <options>.Air\n",
+        "x = <options>.Air\n")
+}
+
+func TestEnumErrors(t *testing.T) {
+    expectCompilationError(t,
+        "enum TrickType {\n    Grind\n    Manual\n    grind\n}\n",
+        "enum 'TrickType' already has a member called 'Grind' (line 4)")
+    expectCompilationError(t,
+        "enum TrickType { Grind Manual }\nx = TrickType.Grnid\n",
+        "'Grnid' isn't a member of enum 'TrickType' (line 2)")
+    expectCompilationError(t,
+        "enum TrickType : float { Grind }\n",
+        "enum 'TrickType' on line 1 has an unknown type 'float' (expected int or checksum)")
+    expectCompilationError(t,
+        "enum TrickType { Grind }\nconst TRICKTYPE = 1\n",
+        "'TRICKTYPE' on line 2 is already defined (as enum 'TrickType' on line 1)")
+    expectCompilationError(t,
+        "script Foo {\n    enum TrickType { Grind }\n}\n",
+        "enum 'TrickType' on line 2 must be declared outside of scripts and other blocks")
+}
@@ -0,0 +1,100 @@
+package newcompiler
+
+import (
+    "testing"
+)
+
+// The hidden locals of a for loop are numbered in the order the loops appear in the file.
+
+func TestForRangeLoop(t *testing.T) {
+    expectSameQb(t,
+        "",
+        "script Foo {\n    for i in 0..10 {\n        Print <i>\n    }\n}\n",
+        "script Foo {\n"+
+            "    <i> = 0\n"+
+            "    <__for_1_end> = 10\n"+
+            "    loop {\n"+
+            "        if (!(<i> < <__for_1_end>)) {\n"+
+            "            break\n"+
+            "        }\n"+
+            "        Print <i>\n"+
+            "        <i> = (<i> + 1)\n"+
+            "    }\n"+
+            "}\n")
+}
+
+func TestForInLoop(t *testing.T) {
+    expectSameQb(t,
+        "",
+        "script Foo {\n    for <skater> in <skaters> {\n        Print <skater>\n    }\n}\n",
+        "script Foo {\n"+
+            "    GetArraySize <skaters>\n"+
+            "    <__for_1_size> = <array_size>\n"+
+            "    <__for_1_index> = 0\n"+
+            "    loop {\n"+
+            "        if (!(<__for_1_index> < <__for_1_size>)) {\n"+
+            "            break\n"+
+            "        }\n"+
+            "        <skater> = <skaters>[<__for_1_index>]\n"+
+            "        Print <skater>\n"+
+            "        <__for_1_index> = (<__for_1_index> + 1)\n"+
+            "    }\n"+
+            "}\n")
+}
+
+func TestNestedForLoops(t *testing.T) {
+    // Each loop keeps its own hidden locals, so the inner loop doesn't reset the outer loop's
+    expectSameQb(t,
+        "",
+        "script Foo {\n    for i in 0..3 {\n        for <item> in <items> {\n            Print <item>\n        }\n    }\n}\n",
+        "script Foo {\n"+
+            "    <i> = 0\n"+
+            "    <__for_1_end> = 3\n"+
+            "    loop {\n"+
+            "        if (!(<i> < <__for_1_end>)) {\n"+
+            "            break\n"+
+            "        }\n"+
+            "        GetArraySize <items>\n"+
+            "        <__for_2_size> = <array_size>\n"+
+            "        <__for_2_index> = 0\n"+
+            "        loop {\n"+
+            "            if (!(<__for_2_index> < <__for_2_size>)) {\n"+
+            "                break\n"+
+            "            }\n"+
+            "            <item> = <items>[<__for_2_index>]\n"+
+            "            Print <item>\n"+
+            "            <__for_2_index> = (<__for_2_index> + 1)\n"+
+            "        }\n"+
+            "        <i> = (<i> + 1)\n"+
+            "    }\n"+
+            "}\n")
+
+    // Two loops over arrays in a row don't share an index either
+    expectSameQb(t,
+        "",
+        "script Foo {\n    for <a> in <as> {\n        Print <a>\n    }\n    for <b> in <bs> {\n        Print <b>\n    }\n}\n",
+        "script Foo {\n"+
+            "    GetArraySize <as>\n"+
+            "    <__for_1_size> = <array_size>\n"+
+            "    <__for_1_index> = 0\n"+
+            "    loop {\n"+
+            "        if (!(<__for_1_index> < <__for_1_size>)) {\n"+
+            "            break\n"+
+            "        }\n"+
+            "        <a> = <as>[<__for_1_index>]\n"+
+            "        Print <a>\n"+
+            "        <__for_1_index> = (<__for_1_index> + 1)\n"+
+            "    }\n"+
+            "    GetArraySize <bs>\n"+
+            "    <__for_2_size> = <array_size>\n"+
+            "    <__for_2_index> = 0\n"+
+            "    loop {\n"+
+            "        if (!(<__for_2_index> < <__for_2_size>)) {\n"+
+            "            break\n"+
+            "        }\n"+
+            "        <b> = <bs>[<__for_2_index>]\n"+
+            "        Print <b>\n"+
+            "        <__for_2_index> = (<__for_2_index> + 1)\n"+
+            "    }\n"+
+            "}\n")
+}
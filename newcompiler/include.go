@@ -0,0 +1,209 @@
+package newcompiler
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+)
+
+// Other files are included with `include "path/to/file.ns"` on a line of its own, outside of scripts and structs.
+// The path is relative to the file doing the including.
+// --------------------------------------------------
+//
+// `include "common.ns"`      -->  common.ns's globals, then this file's globals
+// `script Foo { Bar }`
+//
+// Every file is parsed once, however many times it's included, so each global is only written once. Each file is
+// lexed and parsed on its own so errors name the file they're in. Declarations (e.g. constants) can be used by the
+// files that include them.
+//
+func ParseFile(nsFilePath string) (program Node, includedFilePaths []string, err error) {
+    sourceCode, err := ioutil.ReadFile(nsFilePath)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    includer := includer{
+        filesParsed: map[string]bool{},
+        globals:     map[string]globalDefinition{},
+        constants:   newConstantResolver(),
+        inliner:     newInliner(),
+    }
+    nodes, err := includer.parseFile(nsFilePath, string(sourceCode))
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return wrappedNodes{
+        kind:                NodeKind_Program,
+        nodes:               nodes,
+        extraTokensConsumed: 0,
+    }, includer.filesIncluded, nil
+}
+
+// ---------------- internal -------------------
+
+type includer struct {
+    filesInProgress []string // The chain of includes that led to the file being parsed, for reporting cycles
+    filesParsed     map[string]bool
+    filesIncluded   []string
+    globals         map[string]globalDefinition // By lowercase name
+    constants       *constantResolver           // Shared by every file, so files can use the constants they include
+    inliner         *inliner                    // And the inline scripts
+}
+
+type globalDefinition struct {
+    filePath   string
+    lineNumber uint
+}
+
+type includeDirective struct {
+    path       string
+    lineNumber uint
+}
+
+// Parses a file and everything it includes, returning the nodes for the root of the program.
+func (this *includer) parseFile(nsFilePath string, sourceCode string) ([]Node, error) {
+    baseFilePath := filepath.Base(nsFilePath)
+    absolutePath, _ := filepath.Abs(nsFilePath)
+    this.filesInProgress = append(this.filesInProgress, absolutePath)
+    defer func() {
+        this.filesInProgress = this.filesInProgress[:len(this.filesInProgress)-1]
+        this.filesParsed[absolutePath] = true
+    }()
+
+    tokens, err := Lex(strings.Replace(sourceCode, "\r", "", -1))
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("%s: %s", baseFilePath, err))
+    }
+
+    tokens, includeDirectives := findIncludeDirectives(tokens)
+
+    var nodes []Node
+    for _, directive := range includeDirectives {
+        includedFilePath := filepath.FromSlash(strings.Replace(directive.path, "\\", "/", -1))
+        if !filepath.IsAbs(includedFilePath) {
+            includedFilePath = filepath.Join(filepath.Dir(nsFilePath), includedFilePath)
+        }
+        absoluteIncludedFilePath, _ := filepath.Abs(includedFilePath)
+
+        for i, fileInProgress := range this.filesInProgress {
+            if fileInProgress == absoluteIncludedFilePath {
+                var cycle []string
+                for _, fileInCycle := range this.filesInProgress[i:] {
+                    cycle = append(cycle, filepath.Base(fileInCycle))
+                }
+                cycle = append(cycle, filepath.Base(absoluteIncludedFilePath))
+                return nil, errors.New(fmt.Sprintf("%s: include on line %d makes a cycle (%s)", baseFilePath, directive.lineNumber, strings.Join(cycle, " -> ")))
+            }
+        }
+        if this.filesParsed[absoluteIncludedFilePath] {
+            continue
+        }
+
+        includedSourceCode, err := ioutil.ReadFile(includedFilePath)
+        if err != nil {
+            return nil, errors.New(fmt.Sprintf("%s: can't include '%s' on line %d - %s", baseFilePath, directive.path, directive.lineNumber, err))
+        }
+
+        this.filesIncluded = append(this.filesIncluded, includedFilePath)
+        includedNodes, err := this.parseFile(includedFilePath, string(includedSourceCode))
+        if err != nil {
+            return nil, err
+        }
+        nodes = append(nodes, includedNodes...)
+    }
+
+    // The next file's globals start on a new line
+    if len(tokens) > 0 && tokens[len(tokens)-1].Kind() != TokenKind_NewLine {
+        lastToken := tokens[len(tokens)-1]
+        tokens = append(tokens, newGenericToken(TokenKind_NewLine, "\n", lastToken.LineNumber()+lastToken.LinesConsumed(), 1, 1))
+    }
+
+    program, err := parseWithoutResolving(tokens)
+    if err == nil {
+        program, err = this.constants.resolveConstants(program)
+    }
+    if err == nil {
+        this.inliner.filePath = absolutePath
+        program, err = this.inliner.expandInlineScripts(program)
+    }
+    if err != nil {
+        return nil, errors.New(fmt.Sprintf("%s: %s", baseFilePath, err))
+    }
+
+    ownNodes := program.(wrappedNodes).nodes
+    for _, node := range ownNodes {
+        name, lineNumber, isGlobal := globalNameOf(node)
+        if !isGlobal {
+            continue
+        }
+        key := strings.ToLower(name)
+        if existingDefinition, found := this.globals[key]; found && existingDefinition.filePath != absolutePath {
+            return nil, errors.New(fmt.Sprintf("%s: '%s' on line %d is already defined in %s (line %d)", baseFilePath, name, lineNumber, filepath.Base(existingDefinition.filePath), existingDefinition.lineNumber))
+        }
+        this.globals[key] = globalDefinition{absolutePath, lineNumber}
+    }
+
+    return append(nodes, ownNodes...), nil
+}
+
+// Takes the include directives (and the line breaks after them) out of the tokens.
+func findIncludeDirectives(tokens []Token) ([]Token, []includeDirective) {
+    var remainingTokens []Token
+    var includeDirectives []includeDirective
+
+    nestingDepth := 0
+    isStartOfLine := true
+    for index := 0; index < len(tokens); index++ {
+        token := tokens[index]
+        isEndOfLine := index+2 >= len(tokens) || tokens[index+2].Kind() == TokenKind_NewLine
+        isInclude := nestingDepth == 0 &&
+            isStartOfLine &&
+            token.Kind() == TokenKind_Identifier &&
+            token.Data() == "include" &&
+            index+1 < len(tokens) &&
+            tokens[index+1].Kind() == TokenKind_String &&
+            isEndOfLine
+        if isInclude {
+            includeDirectives = append(includeDirectives, includeDirective{
+                path:       tokens[index+1].Data(),
+                lineNumber: token.LineNumber(),
+            })
+            index += 2
+            continue
+        }
+
+        switch token.Kind() {
+        case TokenKind_LeftCurlyBrace, TokenKind_LeftSquareBracket, TokenKind_LeftParenthesis:
+            nestingDepth++
+        case TokenKind_RightCurlyBrace, TokenKind_RightSquareBracket, TokenKind_RightParenthesis:
+            nestingDepth--
+        }
+        isStartOfLine = token.Kind() == TokenKind_NewLine
+        remainingTokens = append(remainingTokens, token)
+    }
+
+    return remainingTokens, includeDirectives
+}
+
+// Finds the name of a global defined at the root of a file, e.g. `Foo` in `script Foo {}` or `x = 10`.
+func globalNameOf(node Node) (string, uint, bool) {
+    var nameNode Node
+    switch node.Kind() {
+    case NodeKind_Script, NodeKind_AssignmentOperation:
+        operation, isOperation := node.(manyWrappedNodes)
+        if !isOperation {
+            return "", 0, false
+        }
+        nameNode = operation.nodeLists[0][0]
+    default:
+        return "", 0, false
+    }
+    if nameNode.Kind() != NodeKind_QbKey {
+        return "", 0, false
+    }
+    return nameNode.(basicNode).data, nameNode.LineNumber(), true
+}
@@ -0,0 +1,232 @@
+package newcompiler
+
+import (
+    "bytes"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// Writes the files into a temporary directory, then compiles the first one.
+func compileFilesForTest(t *testing.T, files ...[2]string) ([]byte, []string, error) {
+    t.Helper()
+    directory := t.TempDir()
+    for _, file := range files {
+        path := filepath.Join(directory, filepath.FromSlash(file[0]))
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := ioutil.WriteFile(path, []byte(file[1]), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    program, includedFilePaths, err := ParseFile(filepath.Join(directory, filepath.FromSlash(files[0][0])))
+    if err != nil {
+        return nil, nil, err
+    }
+    for i, includedFilePath := range includedFilePaths {
+        relativePath, _ := filepath.Rel(directory, includedFilePath)
+        includedFilePaths[i] = filepath.ToSlash(relativePath)
+    }
+    qb, err := ProduceQb(program)
+    return qb, includedFilePaths, err
+}
+
+func expectIncludeError(t *testing.T, err error, expectedError string) {
+    t.Helper()
+    if err == nil {
+        t.Fatalf("Expected '%s', but it compiled", expectedError)
+    }
+    if err.Error() != expectedError {
+        t.Fatalf("Expected '%s', got '%s'", expectedError, err.Error())
+    }
+}
+
+func TestInclude(t *testing.T) {
+    qb, includedFilePaths, err := compileFilesForTest(t,
+        [2]string{"main.ns", "include \"lib/helpers.ns\"\ninclude \"lib/constants.ns\"\n\nscript Main {\n    Helper\n}\n"},
+        [2]string{"lib/helpers.ns", "include \"constants.ns\"\nscript Helper {\n    Foo\n}"},
+        [2]string{"lib/constants.ns", "speed = 10"},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Each file only once, with the globals of included files first
+    expectedQb := []byte{
+        0x16, 0x09, 0x01, 0xd9, 0xf0, 0x07, 0x17, 0x0a, 0x00, 0x00, 0x00, 0x01, // constants.ns: speed = 10
+        0x23, 0x16, 0x4f, 0x84, 0xc8, 0x78, 0x01, 0x16, 0xde, 0x9a, 0x8c, 0x73, 0x01, 0x24, 0x01, // helpers.ns: script Helper
+        0x23, 0x16, 0x9b, 0x32, 0xd7, 0x40, 0x01, 0x16, 0x4f, 0x84, 0xc8, 0x78, 0x01, 0x24, 0x01, // main.ns: script Main
+        0x00,
+    }
+    if !bytes.Equal(qb, expectedQb) {
+        t.Fatalf("Included files weren't compiled in order:\nExpected: % x\nGot:      % x", expectedQb, qb)
+    }
+
+    expectedIncludedFilePaths := []string{"lib/helpers.ns", "lib/constants.ns"}
+    if !reflect.DeepEqual(includedFilePaths, expectedIncludedFilePaths) {
+        t.Fatalf("Expected the included files to be %q, got %q", expectedIncludedFilePaths, includedFilePaths)
+    }
+}
+
+func TestIncludeOnlyAtTheRoot(t *testing.T) {
+    // Inside a script, `include "x"` is a call with a string argument
+    qb, includedFilePaths, err := compileFilesForTest(t,
+        [2]string{"main.ns", "script Foo {\n    include \"missing.ns\"\n}\n"},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(includedFilePaths) != 0 || !bytes.Contains(qb, []byte("missing.ns")) {
+        t.Fatalf("Expected a call to include:\n% x", qb)
+    }
+}
+
+func TestIncludeCycle(t *testing.T) {
+    _, _, err := compileFilesForTest(t,
+        [2]string{"a.ns", "include \"b.ns\"\n"},
+        [2]string{"b.ns", "x = 1\n\ninclude \"c.ns\"\n"},
+        [2]string{"c.ns", "include \"a.ns\"\n"},
+    )
+    expectIncludeError(t, err, "c.ns: include on line 1 makes a cycle (a.ns -> b.ns -> c.ns -> a.ns)")
+
+    _, _, err = compileFilesForTest(t,
+        [2]string{"a.ns", "include \"a.ns\"\n"},
+    )
+    expectIncludeError(t, err, "a.ns: include on line 1 makes a cycle (a.ns -> a.ns)")
+}
+
+func TestIncludeErrorsNameTheFile(t *testing.T) {
+    _, _, err := compileFilesForTest(t,
+        [2]string{"main.ns", "x = 1\ninclude \"missing.ns\"\n"},
+    )
+    if err == nil || !strings.HasPrefix(err.Error(), "main.ns: can't include 'missing.ns' on line 2 - ") {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    _, _, err = compileFilesForTest(t,
+        [2]string{"main.ns", "include \"other.ns\"\n"},
+        [2]string{"other.ns", "x = \"unterminated\n"},
+    )
+    expectIncludeError(t, err, "other.ns: EOF while scanning string literal")
+}
+
+func TestIncludeDuplicateGlobals(t *testing.T) {
+    _, _, err := compileFilesForTest(t,
+        [2]string{"main.ns", "include \"other.ns\"\n\nscript Foo {\n}\n"},
+        [2]string{"other.ns", "x = 1\n\nscript foo {\n}\n"},
+    )
+    expectIncludeError(t, err, "main.ns: 'Foo' on line 3 is already defined in other.ns (line 3)")
+
+    _, _, err = compileFilesForTest(t,
+        [2]string{"main.ns", "include \"other.ns\"\nx = 2\n"},
+        [2]string{"other.ns", "x = 1\n"},
+    )
+    expectIncludeError(t, err, "main.ns: 'x' on line 2 is already defined in other.ns (line 1)")
+
+    // Assigning the same global twice in one file is still allowed
+    _, _, err = compileFilesForTest(t,
+        [2]string{"main.ns", "include \"other.ns\"\n"},
+        [2]string{"other.ns", "x = 1\nx = 2\n"},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestIncludedConstants(t *testing.T) {
+    qb, _, err := compileFilesForTest(t,
+        [2]string{"main.ns", "include \"constants.ns\"\nx = (SPEED * 2)\n"},
+        [2]string{"constants.ns", "const SPEED = 10\n"},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedQb, err := compileForTest("const SPEED = 10\nx = (SPEED * 2)\n")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(qb, expectedQb) {
+        t.Fatalf("Expected the same as one file:\nExpected: % x\nGot:      % x", expectedQb, qb)
+    }
+}
+
+func TestIncludedConstantErrorsNameTheFile(t *testing.T) {
+    _, _, err := compileFilesForTest(t,
+        [2]string{"main.ns", "include \"constants.ns\"\nconst Speed = 20\n"},
+        [2]string{"constants.ns", "const SPEED = 10\n"},
+    )
+    expectIncludeError(t, err, "main.ns: 'Speed' on line 2 is already defined (as const 'SPEED' on line 1)")
+
+    _, _, err = compileFilesForTest(t,
+        [2]string{"main.ns", "include \"constants.ns\"\nspeed = 5\n"},
+        [2]string{"constants.ns", "const SPEED = 10\n"},
+    )
+    expectIncludeError(t, err, "main.ns: Can't assign to const 'speed' on line 2")
+
+    _, _, err = compileFilesForTest(t,
+        [2]string{"main.ns", "include \"constants.ns\"\nx = A\n"},
+        [2]string{"constants.ns", "const A = A\n"},
+    )
+    expectIncludeError(t, err, "constants.ns: const 'A' on line 1 depends on itself: A -> A")
+}
+
+func TestIncludedEnums(t *testing.T) {
+    qb, _, err := compileFilesForTest(t,
+        [2]string{"main.ns", "include \"enums.ns\"\nx = SkaterState.Air\n"},
+        [2]string{"enums.ns", "enum SkaterState : int { Skating, Air }\n"},
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedQb, err := compileForTest("enum SkaterState : int { Skating, Air }\nx = SkaterState.Air\n")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(qb, expectedQb) {
+        t.Fatalf("Expected the same as one file:\nExpected: % x\nGot:      % x", expectedQb, qb)
+    }
+
+    _, _, err = compileFilesForTest(t,
+        [2]string{"main.ns", "include \"enums.ns\"\nx = SkaterState.Flying\n"},
+        [2]string{"enums.ns", "enum SkaterState : int { Skating, Air }\n"},
+    )
+    if err == nil || !strings.HasPrefix(err.Error(), "main.ns: ") {
+        t.Fatalf("Expected an error in main.ns, got %v", err)
+    }
+}
+
+func TestIncludedInlineScripts(t *testing.T) {
+    helpers := [2]string{"helpers.ns", "inline script AddScore(amount = 10) {\n    Change score = <amount>\n}\n"}
+    qb, _, err := compileFilesForTest(t,
+        [2]string{"main.ns", "include \"helpers.ns\"\n\nscript Foo {\n    AddScore amount = 5\n}\n"},
+        helpers,
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedQb, err := compileForTest(helpers[1] + "\nscript Foo {\n    AddScore amount = 5\n}\n")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(qb, expectedQb) {
+        t.Fatalf("Expected the same as one file:\nExpected: % x\nGot:      % x", expectedQb, qb)
+    }
+
+    // Errors name the file of the definition too
+    _, _, err = compileFilesForTest(t,
+        [2]string{"main.ns", "include \"helpers.ns\"\n\nscript Foo {\n    AddScore points = 5\n}\n"},
+        helpers,
+    )
+    expectIncludeError(t, err, "main.ns: Can't inline 'AddScore' on line 4 (defined in helpers.ns on line 1): it has no parameter called 'points'")
+
+    _, _, err = compileFilesForTest(t,
+        [2]string{"main.ns", "include \"helpers.ns\"\n\ninline script addScore {\n}\n"},
+        helpers,
+    )
+    expectIncludeError(t, err, "main.ns: inline script 'addScore' on line 3 is already defined in helpers.ns (line 1)")
+}
@@ -0,0 +1,332 @@
+package newcompiler
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "strings"
+)
+
+// Inline scripts are copied into the places they're called from instead of being called when the game runs:
+// --------------------------------------------------
+//
+// ```
+// inline script AddScore(amount = 10) {
+//     <total> = (<amount> * 2)
+//     Change score = <total>
+// }
+//
+// script Foo {
+//     AddScore amount = 5
+// }
+// ```
+//
+// becomes
+//
+// ```
+// script Foo {
+//     <__AddScore_1_amount> = 5
+//     <__AddScore_1_total> = (<__AddScore_1_amount> * 2)
+//     Change score = <__AddScore_1_total>
+// }
+// ```
+//
+// Every local variable inside an inline script is renamed, so it can't clash with the caller's locals. Arguments are
+// evaluated by the caller, so they can use the caller's locals. An inline script is called on a line of its own,
+// and can only take arguments that are named in its header.
+//
+type inliner struct {
+    definitions map[string]manyWrappedNodes // By lowercase name
+    filePaths   map[string]string           // The file each inline script is defined in, by lowercase name
+    filePath    string                      // The file being expanded, if the program has more than one
+    callStack   []string                    // The inline scripts being expanded, for reporting recursion
+    expansions  int                         // For giving the locals of each expansion different names
+}
+
+func newInliner() *inliner {
+    return &inliner{
+        definitions: map[string]manyWrappedNodes{},
+        filePaths:   map[string]string{},
+    }
+}
+
+func expandInlineScripts(program Node) (Node, error) {
+    return newInliner().expandInlineScripts(program)
+}
+
+// The definitions are kept, so a program expanded later (e.g. a file that includes this one) can use them.
+func (this *inliner) expandInlineScripts(program Node) (Node, error) {
+    var nodes []Node
+    for _, node := range program.(wrappedNodes).nodes {
+        if node.Kind() != NodeKind_InlineScript {
+            nodes = append(nodes, node)
+            continue
+        }
+        definition := node.(manyWrappedNodes)
+        name := inlineScriptNameOf(definition)
+        key := strings.ToLower(name.data)
+        if existingDefinition, found := this.definitions[key]; found {
+            if existingFilePath := this.filePaths[key]; existingFilePath != this.filePath {
+                return nil, errors.New(fmt.Sprintf("inline script '%s' on line %d is already defined in %s (line %d)", name.data, name.lineNumber, filepath.Base(existingFilePath), inlineScriptNameOf(existingDefinition).lineNumber))
+            }
+            return nil, errors.New(fmt.Sprintf("inline script '%s' on line %d is already defined on line %d", name.data, name.lineNumber, inlineScriptNameOf(existingDefinition).lineNumber))
+        }
+        if err := checkInlineScriptDefinition(definition); err != nil {
+            return nil, err
+        }
+        this.definitions[key] = definition
+        this.filePaths[key] = this.filePath
+    }
+
+    nodes, err := this.expandStatements(nodes)
+    if err != nil {
+        return nil, err
+    }
+
+    programNodes := program.(wrappedNodes)
+    programNodes.nodes = nodes
+    return programNodes, nil
+}
+
+func inlineScriptNameOf(definition manyWrappedNodes) basicNode {
+    return definition.nodeLists[0][0].(basicNode)
+}
+
+// Reports things that can't be copied into another script.
+func checkInlineScriptDefinition(definition manyWrappedNodes) error {
+    name := inlineScriptNameOf(definition)
+
+    for _, parameter := range definition.nodeLists[1] {
+        if _, ok := inlineScriptParameterName(parameter); !ok {
+            return errors.New(fmt.Sprintf("inline script '%s' on line %d can only have named parameters, e.g. `inline script %s(amount = 10)`", name.data, name.lineNumber, name.data))
+        }
+    }
+
+    var check func(Node) (Node, error)
+    check = func(node Node) (Node, error) {
+        switch node.Kind() {
+        case NodeKind_Return:
+            return nil, errors.New(fmt.Sprintf("inline script '%s' on line %d can't use 'return'", name.data, name.lineNumber))
+        case NodeKind_AllArguments:
+            return nil, errors.New(fmt.Sprintf("inline script '%s' on line %d can't use '<...>' (line %d)", name.data, name.lineNumber, node.LineNumber()))
+        case NodeKind_InlineScript, NodeKind_Script:
+            return nil, errors.New(fmt.Sprintf("inline script '%s' on line %d can't contain other scripts", name.data, name.lineNumber))
+        }
+        return rebuildChildren(node, check)
+    }
+    for _, node := range definition.nodeLists[2] {
+        if _, err := check(node); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// The name of a parameter in an inline script's header, e.g. `amount` in `(amount = 10)` or `(amount)`.
+func inlineScriptParameterName(parameter Node) (string, bool) {
+    switch parameter.Kind() {
+    case NodeKind_QbKey:
+        return parameter.(basicNode).data, true
+    case NodeKind_AssignmentOperation:
+        name := assignmentOperands(parameter)[0]
+        if name.Kind() == NodeKind_QbKey {
+            return name.(basicNode).data, true
+        }
+    }
+    return "", false
+}
+
+// Expands the inline scripts called inside a node.
+func (this *inliner) expand(node Node) (Node, error) {
+    switch node.Kind() {
+    case NodeKind_InlineScript:
+        name := inlineScriptNameOf(node.(manyWrappedNodes))
+        return nil, errors.New(fmt.Sprintf("inline script '%s' on line %d must be declared outside of scripts and other blocks", name.data, name.lineNumber))
+    case NodeKind_Program, NodeKind_Else, NodeKind_RandomEntry:
+        statements := node.(wrappedNodes)
+        nodes, err := this.expandStatements(statements.nodes)
+        if err != nil {
+            return nil, err
+        }
+        statements.nodes = nodes
+        return statements, nil
+    case NodeKind_Script, NodeKind_If, NodeKind_Loop:
+        // The body of a script is its 3rd list, the body of an if is its 2nd, and the body of a loop is its 1st
+        bodyIndex := map[NodeKind]int{NodeKind_Script: 2, NodeKind_If: 1, NodeKind_Loop: 0}[node.Kind()]
+        statements := node.(manyWrappedNodes)
+        nodeLists := make([][]Node, len(statements.nodeLists))
+        copy(nodeLists, statements.nodeLists)
+        nodes, err := this.expandStatements(nodeLists[bodyIndex])
+        if err != nil {
+            return nil, err
+        }
+        nodeLists[bodyIndex] = nodes
+        statements.nodeLists = nodeLists
+        return statements, nil
+    }
+    return rebuildChildren(node, this.expand)
+}
+
+// Expands a list of statements, replacing each line that calls an inline script with the inline script's body.
+func (this *inliner) expandStatements(nodes []Node) ([]Node, error) {
+    expandedNodes := []Node{}
+    isStartOfLine := true
+    for i := 0; i < len(nodes); i++ {
+        node := nodes[i]
+        if node == nil {
+            continue
+        }
+
+        if isStartOfLine && node.Kind() == NodeKind_QbKey {
+            if definition, found := this.definitions[strings.ToLower(node.(basicNode).data)]; found {
+                var arguments []Node
+                for i+1 < len(nodes) && nodes[i+1] != nil && nodes[i+1].Kind() != NodeKind_LineBreak {
+                    i++
+                    arguments = append(arguments, nodes[i])
+                }
+                body, err := this.expandCall(node.(basicNode), definition, arguments)
+                if err != nil {
+                    return nil, err
+                }
+                expandedNodes = append(expandedNodes, body...)
+                isStartOfLine = false
+                continue
+            }
+        }
+
+        expandedNode, err := this.expand(node)
+        if err != nil {
+            return nil, err
+        }
+        expandedNodes = append(expandedNodes, expandedNode)
+        isStartOfLine = node.Kind() == NodeKind_LineBreak
+    }
+    return expandedNodes, nil
+}
+
+func (this *inliner) expandCall(call basicNode, definition manyWrappedNodes, arguments []Node) ([]Node, error) {
+    name := inlineScriptNameOf(definition)
+    definedAt := fmt.Sprintf("on line %d", name.lineNumber)
+    if filePath := this.filePaths[strings.ToLower(name.data)]; filePath != this.filePath {
+        definedAt = fmt.Sprintf("in %s on line %d", filepath.Base(filePath), name.lineNumber)
+    }
+    newError := func(message string, args ...interface{}) error {
+        return errors.New(fmt.Sprintf("Can't inline '%s' on line %d (defined %s): %s", call.data, call.lineNumber, definedAt, fmt.Sprintf(message, args...)))
+    }
+
+    for i, scriptInProgress := range this.callStack {
+        if strings.EqualFold(scriptInProgress, name.data) {
+            cycle := append(append([]string{}, this.callStack[i:]...), name.data)
+            return nil, newError("it calls itself (%s)", strings.Join(cycle, " -> "))
+        }
+    }
+    this.callStack = append(this.callStack, name.data)
+    defer func() {
+        this.callStack = this.callStack[:len(this.callStack)-1]
+    }()
+
+    this.expansions++
+    prefix := fmt.Sprintf("__%s_%d_", name.data, this.expansions)
+    renameLocals := func(node Node) (Node, error) {
+        return renameLocalQbKeys(node, prefix)
+    }
+
+    // Arguments, then defaults for the parameters that weren't given
+    values := map[string]Node{}
+    for _, argument := range arguments {
+        if argument.Kind() == NodeKind_Comma {
+            continue
+        }
+        if argument.Kind() != NodeKind_AssignmentOperation {
+            return nil, newError("arguments must be named, e.g. `%s amount = 10`", call.data)
+        }
+        argumentName, ok := inlineScriptParameterName(argument)
+        if !ok {
+            return nil, newError("arguments must be named, e.g. `%s amount = 10`", call.data)
+        }
+        if findInlineScriptParameter(definition, argumentName) == nil {
+            return nil, newError("it has no parameter called '%s'", argumentName)
+        }
+        value, err := this.expand(assignmentOperands(argument)[1])
+        if err != nil {
+            return nil, err
+        }
+        values[strings.ToLower(argumentName)] = value
+    }
+
+    var body []Node
+    for _, parameter := range definition.nodeLists[1] {
+        parameterName, _ := inlineScriptParameterName(parameter)
+        value, found := values[strings.ToLower(parameterName)]
+        if !found {
+            if parameter.Kind() != NodeKind_AssignmentOperation {
+                // Like a script that wasn't given the parameter
+                continue
+            }
+            defaultValue, err := renameLocals(assignmentOperands(parameter)[1])
+            if err != nil {
+                return nil, err
+            }
+            value = defaultValue
+        }
+
+        local := wrappedNode{
+            kind: NodeKind_LocalQbKey,
+            node: basicNode{
+                kind:           NodeKind_QbKey,
+                data:           prefix + parameterName,
+                tokensConsumed: 1,
+                lineNumber:     call.lineNumber,
+            },
+            extraTokensConsumed: 2,
+        }
+        body = append(body,
+            manyWrappedNodes{
+                kind:                NodeKind_AssignmentOperation,
+                nodeLists:           [][]Node{{local, value}, {}, {}},
+                extraTokensConsumed: 1,
+            },
+            basicNode{
+                kind:           NodeKind_LineBreak,
+                data:           "\n",
+                tokensConsumed: 1,
+                lineNumber:     call.lineNumber,
+            },
+        )
+    }
+
+    // Without the line breaks after the `{` and before the `}`, the call's own line break comes after
+    for _, node := range withoutSurroundingLineBreaks(definition.nodeLists[2]) {
+        renamedNode, err := renameLocals(node)
+        if err != nil {
+            return nil, err
+        }
+        body = append(body, renamedNode)
+    }
+
+    return this.expandStatements(body)
+}
+
+func findInlineScriptParameter(definition manyWrappedNodes, name string) Node {
+    for _, parameter := range definition.nodeLists[1] {
+        if parameterName, _ := inlineScriptParameterName(parameter); strings.EqualFold(parameterName, name) {
+            return parameter
+        }
+    }
+    return nil
+}
+
+// Renames every `<local>` inside a node, e.g. `<amount>` to `<__AddScore_1_amount>`.
+func renameLocalQbKeys(node Node, prefix string) (Node, error) {
+    if node.Kind() == NodeKind_LocalQbKey {
+        local := node.(wrappedNode)
+        if name, ok := local.node.(basicNode); ok {
+            name.data = prefix + name.data
+            local.node = name
+        }
+        return local, nil
+    }
+    return rebuildChildren(node, func(child Node) (Node, error) {
+        return renameLocalQbKeys(child, prefix)
+    })
+}
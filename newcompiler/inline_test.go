@@ -0,0 +1,62 @@
+package newcompiler
+
+import (
+    "testing"
+)
+
+const addScore = "inline script AddScore(amount = 10) {\n    <total> = (<amount> * 2)\n    Change score = <total>\n}\n"
+
+func TestInlineScriptExpansion(t *testing.T) {
+    expectSameQb(t,
+        addScore,
+        "script Foo {\n    AddScore amount = 5\n}\n",
+        "script Foo {\n    <__AddScore_1_amount> = 5\n    <__AddScore_1_total> = (<__AddScore_1_amount> * 2)\n    Change score = <__AddScore_1_total>\n}\n")
+
+    // Inside blocks, and calling other inline scripts
+    expectSameQb(t,
+        addScore+"inline script Double {\n    AddScore amount = 1\n    AddScore\n}\n",
+        "script Foo {\n    if (<x>) {\n        Double\n    }\n}\n",
+        "script Foo {\n    if (<x>) {\n        <__AddScore_2_amount> = 1\n        <__AddScore_2_total> = (<__AddScore_2_amount> * 2)\n        Change score = <__AddScore_2_total>\n        <__AddScore_3_amount> = 10\n        <__AddScore_3_total> = (<__AddScore_3_amount> * 2)\n        Change score = <__AddScore_3_total>\n    }\n}\n")
+}
+
+func TestInlineScriptDefaultParameters(t *testing.T) {
+    expectSameQb(t,
+        addScore,
+        "script Foo {\n    AddScore\n}\n",
+        "script Foo {\n    <__AddScore_1_amount> = 10\n    <__AddScore_1_total> = (<__AddScore_1_amount> * 2)\n    Change score = <__AddScore_1_total>\n}\n")
+
+    // A parameter without a default isn't set when it isn't given, like a script's parameter
+    expectSameQb(t,
+        "inline script Show(text) {\n    Print <text>\n}\n",
+        "script Foo {\n    Show\n}\n",
+        "script Foo {\n    Print <__Show_1_text>\n}\n")
+}
+
+func TestInlineScriptLocalsDontClash(t *testing.T) {
+    // The caller's <total> and the inline script's <total> are different variables, and arguments use the caller's
+    expectSameQb(t,
+        addScore,
+        "script Foo {\n    <total> = 1\n    AddScore amount = <total>\n    AddScore amount = <total>\n    Print <total>\n}\n",
+        "script Foo {\n    <total> = 1\n"+
+            "    <__AddScore_1_amount> = <total>\n    <__AddScore_1_total> = (<__AddScore_1_amount> * 2)\n    Change score = <__AddScore_1_total>\n"+
+            "    <__AddScore_2_amount> = <total>\n    <__AddScore_2_total> = (<__AddScore_2_amount> * 2)\n    Change score = <__AddScore_2_total>\n"+
+            "    Print <total>\n}\n")
+}
+
+func TestInlineScriptErrors(t *testing.T) {
+    expectCompilationError(t,
+        "inline script A {\n    B\n}\ninline script B {\n    A\n}\nscript Foo {\n    A\n}\n",
+        "Can't inline 'A' on line 5 (defined on line 1): it calls itself (A -> B -> A)")
+    expectCompilationError(t,
+        addScore+"script Foo {\n    AddScore 5\n}\n",
+        "Can't inline 'AddScore' on line 6 (defined on line 1): arguments must be named, e.g. `AddScore amount = 10`")
+    expectCompilationError(t,
+        addScore+"script Foo {\n    AddScore points = 5\n}\n",
+        "Can't inline 'AddScore' on line 6 (defined on line 1): it has no parameter called 'points'")
+    expectCompilationError(t,
+        "inline script Foo {\n    return\n}\n",
+        "inline script 'Foo' on line 1 can't use 'return'")
+    expectCompilationError(t,
+        "inline script Foo {\n}\ninline script foo {\n}\n",
+        "inline script 'foo' on line 3 is already defined on line 1")
+}
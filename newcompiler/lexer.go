@@ -53,6 +53,10 @@ const (
     TokenKind_Space
     TokenKind_Tab
     TokenKind_CarriageReturn
+    TokenKind_Const
+    TokenKind_Enum
+    TokenKind_Inline
+    TokenKind_For
 )
 
 type Token interface {
@@ -473,6 +477,38 @@ func (this *lexer) lex() ([]Token, error) {
             continue
         }
 
+        const_, err := this.tryGetKeyword("const", TokenKind_Const)
+        if err != nil {
+            return nil, err
+        } else if const_ != nil {
+            _ = this.saveToken(const_)
+            continue
+        }
+
+        enum, err := this.tryGetKeyword("enum", TokenKind_Enum)
+        if err != nil {
+            return nil, err
+        } else if enum != nil {
+            _ = this.saveToken(enum)
+            continue
+        }
+
+        inline, err := this.tryGetKeyword("inline", TokenKind_Inline)
+        if err != nil {
+            return nil, err
+        } else if inline != nil {
+            _ = this.saveToken(inline)
+            continue
+        }
+
+        for_, err := this.tryGetKeyword("for", TokenKind_For)
+        if err != nil {
+            return nil, err
+        } else if for_ != nil {
+            _ = this.saveToken(for_)
+            continue
+        }
+
         identifier, err := this.tryGetIdentifier()
         if err != nil {
             return nil, err
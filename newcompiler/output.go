@@ -8,13 +8,18 @@ import (
     "fmt"
     "github.com/byxor/NeverScript/compiler"
     "math"
+    "sort"
     "strconv"
 )
 
 func ProduceQb(program Node) ([]byte, error) {
+    return ProduceQbWithSettings(program, Settings{})
+}
+
+func ProduceQbWithSettings(program Node, settings Settings) ([]byte, error) {
     var output output
     output.engineSupportsIf2 = false
-    output.writeLineNumbers = false
+    output.writeLineNumbers = settings.WriteLineNumbers
     output.writeQbKeys = false
     output.nameTable = make(map[string]uint32)
     err := output.writeQb(program)
@@ -77,6 +82,13 @@ func (this *output) writeQb(node Node) error {
     case NodeKind_Comma:
         this.write(0x9)
         return nil
+    case NodeKind_ChunkOfCode:
+        return this.writeChunkOfCodeQb(node)
+    case NodeKind_Break:
+        this.write(0x22)
+        return nil
+    case NodeKind_ArrayAccessOperation:
+        return this.writeArrayAccessOperationQb(node)
     case NodeKind_Loop:
         return this.writeLoopQb(node)
     case NodeKind_IfStatement:
@@ -146,7 +158,7 @@ func (this *output) writeQb(node Node) error {
     case NodeKind_ElseIf:
         fallthrough
     default:
-        return errors.New(fmt.Sprintf("QB output not implemented for '%s'", node.Kind()))
+        return errors.New(fmt.Sprintf("QB output not implemented for node kind %d", node.Kind()))
     }
 }
 
@@ -159,7 +171,14 @@ func (this *output) writeProgramQb(node Node) error {
     }
 
     if this.writeQbKeys {
-        for identifier, qbKey := range this.nameTable {
+        // Sorted so that the same code always compiles to the same bytes
+        identifiers := make([]string, 0, len(this.nameTable))
+        for identifier := range this.nameTable {
+            identifiers = append(identifiers, identifier)
+        }
+        sort.Strings(identifiers)
+        for _, identifier := range identifiers {
+            qbKey := this.nameTable[identifier]
             this.write(0x2B)
             this.writeLittleEndianUint32(qbKey)
             this.write([]byte(identifier)...)
@@ -216,6 +235,33 @@ func (this *output) writeBinaryOperationQb(node Node, operators ...byte) error {
     return nil
 }
 
+func (this *output) writeChunkOfCodeQb(node Node) error {
+    for _, innerNode := range node.(fixedSizeWrappedNode).node.nodeLists[0] {
+        err := this.writeQb(innerNode)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (this *output) writeArrayAccessOperationQb(node Node) error {
+    array := node.(wrappedNodes).nodes[0]
+    index := node.(wrappedNodes).nodes[1]
+
+    err := this.writeQb(array)
+    if err != nil {
+        return err
+    }
+    this.write(0x5)
+    err = this.writeQb(index)
+    if err != nil {
+        return err
+    }
+    this.write(0x6)
+    return nil
+}
+
 func (this *output) writeScriptQb(node Node) error {
     manyWrappedNodes := node.(manyWrappedNodes)
     this.write(0x23)
@@ -0,0 +1,41 @@
+package newcompiler
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestWriteLineNumbers(t *testing.T) {
+    tokens, err := Lex("x = 1\n\nscript Foo {\n    Bar\n}\n")
+    if err != nil {
+        t.Fatal(err)
+    }
+    program, err := Parse(tokens)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    qb, err := ProduceQbWithSettings(program, Settings{WriteLineNumbers: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expectedQb := []byte{
+        0x16, 0x7c, 0xe9, 0x23, 0x73, 0x07, 0x17, 0x01, 0x00, 0x00, 0x00, 0x02, 0x01, 0x00, 0x00, 0x00,
+        0x23, 0x16, 0xde, 0x9a, 0x8c, 0x73, 0x02, 0x03, 0x00, 0x00, 0x00,
+        0x16, 0x55, 0x73, 0x00, 0x89, 0x02, 0x04, 0x00, 0x00, 0x00,
+        0x24, 0x02, 0x05, 0x00, 0x00, 0x00,
+        0x00,
+    }
+    if !bytes.Equal(qb, expectedQb) {
+        t.Fatalf("Expected:\n% x\nGot:\n% x", expectedQb, qb)
+    }
+
+    // Plain new-lines otherwise
+    qb, err = ProduceQb(program)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if bytes.Contains(qb, []byte{0x02}) {
+        t.Fatalf("Expected no line numbers:\n% x", qb)
+    }
+}
@@ -66,6 +66,9 @@ const (
     NodeKind_SuperExpression
     NodeKind_LineBreak
     NodeKind_Program
+    NodeKind_Const
+    NodeKind_Enum
+    NodeKind_InlineScript
 )
 
 type Node interface {
@@ -75,6 +78,18 @@ type Node interface {
 }
 
 func Parse(tokens []Token) (Node, error) {
+    program, err := parseWithoutResolving(tokens)
+    if err != nil {
+        return nil, err
+    }
+    program, err = resolveConstants(program)
+    if err != nil {
+        return nil, err
+    }
+    return expandInlineScripts(program)
+}
+
+func parseWithoutResolving(tokens []Token) (Node, error) {
     var parser parser
     parser.tokens = tokens
     parser.compressAST = true
@@ -254,6 +269,7 @@ type parser struct {
     operationCache           map[uint]Node
     expressionCache          map[uint]Node
     subExpressionCache       map[uint]Node
+    forLoops                 int // For giving the locals of each for loop different names
 }
 
 func (this *parser) isOutOfRangeAt(index uint) bool {
@@ -324,7 +340,7 @@ func (this *parser) tryParseChunkOfCodeAt(index uint) (Node, error) {
     }, nil
 }
 
-// IfStatement | Loop | Switch | "break" | Return | Expression
+// Const | Enum | IfStatement | Loop | For | Switch | "break" | Return | Expression
 func (this *parser) tryParseSuperExpressionAt(index uint) (Node, error) {
     cachedNode, found := this.superExpressionCache[index]
     if found {
@@ -343,7 +359,7 @@ func (this *parser) _tryParseSuperExpressionAt(index uint) (Node, error) {
         return nil, nil
     }
 
-    var ifStatement, loop, switch_, break_, return_, expression Node
+    var const_, enum, ifStatement, loop, for_, switch_, break_, return_, expression Node
     var err error
 
     node := wrappedNode{
@@ -352,6 +368,22 @@ func (this *parser) _tryParseSuperExpressionAt(index uint) (Node, error) {
         extraTokensConsumed: 0,
     }
 
+    const_, err = this.tryParseConstAt(index)
+    if err != nil {
+        return nil, err
+    } else if const_ != nil {
+        node.node = const_
+        goto foundNode
+    }
+
+    enum, err = this.tryParseEnumAt(index)
+    if err != nil {
+        return nil, err
+    } else if enum != nil {
+        node.node = enum
+        goto foundNode
+    }
+
     ifStatement, err = this.tryParseIfStatementAt(index)
     if err != nil {
         return nil, err
@@ -368,6 +400,14 @@ func (this *parser) _tryParseSuperExpressionAt(index uint) (Node, error) {
         goto foundNode
     }
 
+    for_, err = this.tryParseForAt(index)
+    if err != nil {
+        return nil, err
+    } else if for_ != nil {
+        node.node = for_
+        goto foundNode
+    }
+
     switch_, err = this.tryParseSwitchAt(index)
     if err != nil {
         return nil, err
@@ -1670,7 +1710,7 @@ func (this *parser) tryParseScriptHeaderAt(index uint) (Node, error) {
     }, nil
 }
 
-// "script" QbKey ScriptHeader? LineBreak* "{" ChunkOfCode "}"
+// "inline"? "script" QbKey ScriptHeader? LineBreak* "{" ChunkOfCode "}"
 func (this *parser) tryParseScriptAt(index uint) (Node, error) {
     if this.isOutOfRangeAt(index) {
         return nil, nil
@@ -1678,6 +1718,16 @@ func (this *parser) tryParseScriptAt(index uint) (Node, error) {
 
     extraTokensConsumed := uint(0)
 
+    kind := NodeKind_Script
+    if this.tokens[index].Kind() == TokenKind_Inline {
+        kind = NodeKind_InlineScript
+        index++
+        extraTokensConsumed++
+        if this.isOutOfRangeAt(index) {
+            return nil, nil
+        }
+    }
+
     if this.tokens[index].Kind() != TokenKind_Script {
         return nil, nil
     }
@@ -1750,7 +1800,7 @@ func (this *parser) tryParseScriptAt(index uint) (Node, error) {
     }
 
     return manyWrappedNodes{
-        kind: NodeKind_Script,
+        kind: kind,
         nodeLists: [][]Node{
             {qbKey},
             headerNodes,
@@ -2186,6 +2236,90 @@ func (this *parser) tryParseLoopAt(index uint) (Node, error) {
     }, nil
 }
 
+// "for" ("<" QbKey ">" | QbKey) "in" (Expression ".." Expression | Expression) "{" ChunkOfCode "}"
+func (this *parser) tryParseForAt(index uint) (Node, error) {
+    if this.isOutOfRangeAt(index) {
+        return nil, nil
+    }
+
+    forToken := this.tokens[index]
+    if forToken.Kind() != TokenKind_For {
+        return nil, nil
+    }
+    startIndex := index
+    index++
+    this.forLoops++
+    prefix := fmt.Sprintf("__for_%d_", this.forLoops)
+
+    usage := errors.New(fmt.Sprintf("for loop on line %d should look like `for <item> in <array> { ... }` or `for i in 0..10 { ... }`", forToken.LineNumber()))
+
+    // `i` is short for `<i>`
+    variable, err := this.tryParseLocalQbKeyAt(index)
+    if err != nil {
+        return nil, err
+    } else if variable == nil {
+        qbKey, err := this.tryParseQbKeyAt(index)
+        if err != nil {
+            return nil, err
+        } else if qbKey == nil || qbKey.Kind() != NodeKind_QbKey {
+            return nil, usage
+        }
+        variable = wrappedNode{
+            kind:                NodeKind_LocalQbKey,
+            node:                qbKey,
+            extraTokensConsumed: 0,
+        }
+    }
+    index += variable.TokensConsumed()
+
+    if this.isOutOfRangeAt(index) || this.tokens[index].Kind() != TokenKind_Identifier || this.tokens[index].Data() != "in" {
+        return nil, usage
+    }
+    index++
+
+    iterable, err := this.tryParseExpressionAt(index)
+    if err != nil {
+        return nil, err
+    } else if iterable == nil {
+        return nil, usage
+    }
+    index += iterable.TokensConsumed()
+
+    var rangeEnd Node
+    if !this.isOutOfRangeAt(index+1) && this.tokens[index].Kind() == TokenKind_Dot && this.tokens[index+1].Kind() == TokenKind_Dot {
+        index += 2
+        rangeEnd, err = this.tryParseExpressionAt(index)
+        if err != nil {
+            return nil, err
+        } else if rangeEnd == nil {
+            return nil, usage
+        }
+        index += rangeEnd.TokensConsumed()
+    }
+
+    if this.isOutOfRangeAt(index) || this.tokens[index].Kind() != TokenKind_LeftCurlyBrace {
+        return nil, usage
+    }
+    index++
+
+    bodyChunk, err := this.tryParseChunkOfCodeAt(index)
+    if err != nil {
+        return nil, err
+    }
+    index += bodyChunk.TokensConsumed()
+
+    if this.isOutOfRangeAt(index) || this.tokens[index].Kind() != TokenKind_RightCurlyBrace {
+        return nil, errors.New(fmt.Sprintf("for loop on line %d is missing a '}'", forToken.LineNumber()))
+    }
+    index++
+
+    body := notNilNodes(bodyChunk.(wrappedNodes).nodes)
+    if rangeEnd != nil {
+        return simplifyForRangeLoop(variable, iterable, rangeEnd, body, prefix, forToken.LineNumber(), index-startIndex)
+    }
+    return simplifyForInLoop(variable, iterable, body, prefix, forToken.LineNumber(), index-startIndex)
+}
+
 // "switch" Expression "{" (Case ChunkOfCode)* "}"
 func (this *parser) tryParseSwitchAt(index uint) (Node, error) {
     if this.isOutOfRangeAt(index) {
@@ -2197,6 +2331,136 @@ func (this *parser) tryParseSwitchAt(index uint) (Node, error) {
     return nil, nil
 }
 
+// "const" QbKey "=" Expression
+func (this *parser) tryParseConstAt(index uint) (Node, error) {
+    if this.isOutOfRangeAt(index) {
+        return nil, nil
+    }
+
+    constToken := this.tokens[index]
+    if constToken.Kind() != TokenKind_Const {
+        return nil, nil
+    }
+    index++
+
+    name, err := this.tryParseQbKeyAt(index)
+    if err != nil {
+        return nil, err
+    } else if name == nil || name.Kind() != NodeKind_QbKey {
+        return nil, errors.New(fmt.Sprintf("const on line %d needs a name, e.g. `const MAX_SPEED = 10`", constToken.LineNumber()))
+    }
+    index += name.TokensConsumed()
+
+    if this.isOutOfRangeAt(index) || this.tokens[index].Kind() != TokenKind_Equals {
+        return nil, errors.New(fmt.Sprintf("const '%s' on line %d needs a value, e.g. `const %s = 10`", name.(basicNode).data, constToken.LineNumber(), name.(basicNode).data))
+    }
+    index++
+
+    value, err := this.tryParseExpressionAt(index)
+    if err != nil {
+        return nil, err
+    } else if value == nil {
+        return nil, errors.New(fmt.Sprintf("const '%s' on line %d needs a value, e.g. `const %s = 10`", name.(basicNode).data, constToken.LineNumber(), name.(basicNode).data))
+    }
+
+    return wrappedNodes{
+        kind:                NodeKind_Const,
+        nodes:               []Node{name, value},
+        extraTokensConsumed: 2,
+    }, nil
+}
+
+// "enum" QbKey [":" QbKey] "{" (QbKey | "," | LineBreak)* "}"
+func (this *parser) tryParseEnumAt(index uint) (Node, error) {
+    if this.isOutOfRangeAt(index) {
+        return nil, nil
+    }
+
+    enumToken := this.tokens[index]
+    if enumToken.Kind() != TokenKind_Enum {
+        return nil, nil
+    }
+    index++
+    extraTokensConsumed := uint(1)
+
+    name, err := this.tryParseQbKeyAt(index)
+    if err != nil {
+        return nil, err
+    } else if name == nil || name.Kind() != NodeKind_QbKey {
+        return nil, errors.New(fmt.Sprintf("enum on line %d needs a name, e.g. `enum TrickType { Grind Manual }`", enumToken.LineNumber()))
+    }
+    index += name.TokensConsumed()
+
+    // The type of the members, e.g. `enum SkaterState : int { ... }`
+    var memberType []Node
+    if !this.isOutOfRangeAt(index) && this.tokens[index].Kind() == TokenKind_Colon {
+        index++
+        extraTokensConsumed++
+        typeName, err := this.tryParseQbKeyAt(index)
+        if err != nil {
+            return nil, err
+        } else if typeName == nil || typeName.Kind() != NodeKind_QbKey {
+            return nil, errors.New(fmt.Sprintf("enum '%s' on line %d needs a type after ':' (int or checksum)", name.(basicNode).data, enumToken.LineNumber()))
+        }
+        memberType = append(memberType, typeName)
+        index += typeName.TokensConsumed()
+    }
+
+    if this.isOutOfRangeAt(index) || this.tokens[index].Kind() != TokenKind_LeftCurlyBrace {
+        return nil, errors.New(fmt.Sprintf("enum '%s' on line %d needs a list of members, e.g. `enum %s { Grind Manual }`", name.(basicNode).data, enumToken.LineNumber(), name.(basicNode).data))
+    }
+    index++
+    extraTokensConsumed++
+
+    var members nodeArray
+    members.nodes = []Node{}
+    for {
+        if this.isOutOfRangeAt(index) {
+            return nil, errors.New(fmt.Sprintf("enum '%s' on line %d is missing a '}'", name.(basicNode).data, enumToken.LineNumber()))
+        }
+
+        member, err := this.tryParseQbKeyAt(index)
+        if err != nil {
+            return nil, err
+        } else if member != nil && member.Kind() == NodeKind_QbKey {
+            members.save(member)
+            index += member.TokensConsumed()
+            continue
+        }
+
+        comma, err := this.tryParseCommaAt(index)
+        if err != nil {
+            return nil, err
+        } else if comma != nil {
+            members.save(comma)
+            index += comma.TokensConsumed()
+            continue
+        }
+
+        lineBreak, err := this.tryParseLineBreakAt(index)
+        if err != nil {
+            return nil, err
+        } else if lineBreak != nil {
+            members.save(lineBreak)
+            index += lineBreak.TokensConsumed()
+            continue
+        }
+
+        break
+    }
+
+    if this.tokens[index].Kind() != TokenKind_RightCurlyBrace {
+        return nil, errors.New(fmt.Sprintf("enum '%s' on line %d can only contain member names, found '%s' on line %d", name.(basicNode).data, enumToken.LineNumber(), this.tokens[index].Data(), this.tokens[index].LineNumber()))
+    }
+    extraTokensConsumed++
+
+    return manyWrappedNodes{
+        kind:                NodeKind_Enum,
+        nodeLists:           [][]Node{{name}, memberType, members.nodes},
+        extraTokensConsumed: extraTokensConsumed,
+    }, nil
+}
+
 // "break"
 func (this *parser) tryParseBreakAt(index uint) (Node, error) {
     if this.isOutOfRangeAt(index) {
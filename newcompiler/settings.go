@@ -2,5 +2,5 @@ package newcompiler
 
 type Settings struct {
     preventConsecutiveLineBreaks bool
-    writeLineNumbers             bool
+    WriteLineNumbers             bool // Line breaks are written as 0x02 followed by the line number of the source code.
 }
@@ -185,3 +185,259 @@ func simplifyGreaterThanEqualOperation(greaterThanEqual Node) (Node, error) {
         extraTokensConsumed: 0,
     }, nil
 }
+
+/*
+Turns a counted for loop into a loop that keeps its own index.
+
+```
+for i in 0..10 {
+	Print <i>
+}
+```
+
+becomes
+
+```
+<i> = 0
+<__for_N_end> = 10
+loop {
+	if !(<i> < <__for_N_end>) {
+		break
+	}
+	Print <i>
+	<i> = (<i> + 1)
+}
+```
+*/
+func simplifyForRangeLoop(variable Node, rangeStart Node, rangeEnd Node, body []Node, prefix string, lineNumber uint, tokensConsumed uint) (Node, error) {
+    end := newSyntheticLocal(prefix+"end", lineNumber)
+
+    loopBody := []Node{
+        newSyntheticLineBreak(lineNumber),
+        newSyntheticBreakUnlessLessThan(variable, end, lineNumber), newSyntheticLineBreak(lineNumber),
+    }
+    loopBody = append(loopBody, withoutSurroundingLineBreaks(body)...)
+    loopBody = append(loopBody, newSyntheticLineBreak(lineNumber), newSyntheticIncrement(variable), newSyntheticLineBreak(lineNumber))
+
+    return newSyntheticChunkOfCode(tokensConsumed,
+        newSyntheticAssignment(variable, rangeStart), newSyntheticLineBreak(lineNumber),
+        newSyntheticAssignment(end, rangeEnd), newSyntheticLineBreak(lineNumber),
+        newSyntheticLoop(loopBody),
+    ), nil
+}
+
+/*
+Turns a for loop over an array into a loop that keeps its own index. GetArraySize always writes to `<array_size>`, so
+the loop overwrites any `<array_size>` the script already had. The size is copied into the loop's own local straight
+away, so calling GetArraySize inside the loop doesn't change how many times it runs.
+
+```
+for <skater> in <skaters> {
+	Print <skater>
+}
+```
+
+becomes
+
+```
+GetArraySize <skaters>
+<__for_N_size> = <array_size>
+<__for_N_index> = 0
+loop {
+	if !(<__for_N_index> < <__for_N_size>) {
+		break
+	}
+	<skater> = <skaters>[<__for_N_index>]
+	Print <skater>
+	<__for_N_index> = (<__for_N_index> + 1)
+}
+```
+*/
+func simplifyForInLoop(variable Node, array Node, body []Node, prefix string, lineNumber uint, tokensConsumed uint) (Node, error) {
+    size := newSyntheticLocal(prefix+"size", lineNumber)
+    index := newSyntheticLocal(prefix+"index", lineNumber)
+
+    item := wrappedNodes{
+        kind:                NodeKind_ArrayAccessOperation,
+        nodes:               []Node{array, index},
+        extraTokensConsumed: 0,
+    }
+    loopBody := []Node{
+        newSyntheticLineBreak(lineNumber),
+        newSyntheticBreakUnlessLessThan(index, size, lineNumber), newSyntheticLineBreak(lineNumber),
+        newSyntheticAssignment(variable, item), newSyntheticLineBreak(lineNumber),
+    }
+    loopBody = append(loopBody, withoutSurroundingLineBreaks(body)...)
+    loopBody = append(loopBody, newSyntheticLineBreak(lineNumber), newSyntheticIncrement(index), newSyntheticLineBreak(lineNumber))
+
+    getArraySize := basicNode{kind: NodeKind_QbKey, data: "GetArraySize", tokensConsumed: 0, lineNumber: lineNumber}
+    return newSyntheticChunkOfCode(tokensConsumed,
+        getArraySize, array, newSyntheticLineBreak(lineNumber),
+        newSyntheticAssignment(size, newSyntheticLocal("array_size", lineNumber)), newSyntheticLineBreak(lineNumber),
+        newSyntheticAssignment(index, basicNode{kind: NodeKind_Int, data: "0", tokensConsumed: 0, lineNumber: lineNumber}), newSyntheticLineBreak(lineNumber),
+        newSyntheticLoop(loopBody),
+    ), nil
+}
+
+func withoutSurroundingLineBreaks(nodes []Node) []Node {
+    for len(nodes) > 0 && nodes[0].Kind() == NodeKind_LineBreak {
+        nodes = nodes[1:]
+    }
+    for len(nodes) > 0 && nodes[len(nodes)-1].Kind() == NodeKind_LineBreak {
+        nodes = nodes[:len(nodes)-1]
+    }
+    return nodes
+}
+
+// The nodes below are made by the compiler rather than parsed, so they don't consume any tokens.
+
+func newSyntheticChunkOfCode(tokensConsumed uint, nodes ...Node) Node {
+    return fixedSizeWrappedNode{
+        node: manyWrappedNodes{
+            kind:                NodeKind_ChunkOfCode,
+            nodeLists:           [][]Node{nodes},
+            extraTokensConsumed: 0,
+        },
+        tokensConsumed: tokensConsumed,
+    }
+}
+
+func newSyntheticLocal(name string, lineNumber uint) Node {
+    return wrappedNode{
+        kind:                NodeKind_LocalQbKey,
+        node:                basicNode{kind: NodeKind_QbKey, data: name, tokensConsumed: 0, lineNumber: lineNumber},
+        extraTokensConsumed: 0,
+    }
+}
+
+func newSyntheticLineBreak(lineNumber uint) Node {
+    return basicNode{kind: NodeKind_LineBreak, data: "\n", tokensConsumed: 0, lineNumber: lineNumber}
+}
+
+func newSyntheticAssignment(leftHandSide Node, rightHandSide Node) Node {
+    return manyWrappedNodes{
+        kind:                NodeKind_AssignmentOperation,
+        nodeLists:           [][]Node{{leftHandSide, rightHandSide}, {}, {}},
+        extraTokensConsumed: 0,
+    }
+}
+
+// `<local> = (<local> + 1)`
+func newSyntheticIncrement(local Node) Node {
+    return newSyntheticAssignment(local, wrappedNode{
+        kind: NodeKind_ParenthesisOperation,
+        node: manyWrappedNodes{
+            kind: NodeKind_PlusOperation,
+            nodeLists: [][]Node{
+                {local, basicNode{kind: NodeKind_Int, data: "1", tokensConsumed: 0, lineNumber: local.LineNumber()}},
+                {},
+                {},
+            },
+            extraTokensConsumed: 0,
+        },
+        extraTokensConsumed: 0,
+    })
+}
+
+// `if !(<a> < <b>) { break }`
+func newSyntheticBreakUnlessLessThan(leftHandSide Node, rightHandSide Node, lineNumber uint) Node {
+    condition := wrappedNode{
+        kind: NodeKind_NotOperation,
+        node: wrappedNode{
+            kind: NodeKind_ParenthesisOperation,
+            node: manyWrappedNodes{
+                kind:                NodeKind_LessThanOperation,
+                nodeLists:           [][]Node{{leftHandSide, rightHandSide}, {}, {}},
+                extraTokensConsumed: 0,
+            },
+            extraTokensConsumed: 0,
+        },
+        extraTokensConsumed: 0,
+    }
+    return wrappedNodes{
+        kind: NodeKind_IfStatement,
+        nodes: []Node{
+            manyWrappedNodes{
+                kind: NodeKind_If,
+                nodeLists: [][]Node{
+                    {condition},
+                    {
+                        newSyntheticLineBreak(lineNumber),
+                        basicNode{kind: NodeKind_Break, data: "break", tokensConsumed: 0, lineNumber: lineNumber},
+                        newSyntheticLineBreak(lineNumber),
+                    },
+                },
+                extraTokensConsumed: 0,
+            },
+            nil,
+        },
+        extraTokensConsumed: 0,
+    }
+}
+
+func newSyntheticLoop(body []Node) Node {
+    return manyWrappedNodes{
+        kind:                NodeKind_Loop,
+        nodeLists:           [][]Node{body, {nil}},
+        extraTokensConsumed: 0,
+    }
+}
+
+// Returns a copy of a node with visit(child) in place of each node directly inside it.
+func rebuildChildren(node Node, visit func(Node) (Node, error)) (Node, error) {
+    visitAll := func(nodes []Node) ([]Node, error) {
+        if nodes == nil {
+            return nil, nil
+        }
+        visitedNodes := make([]Node, len(nodes))
+        for i, child := range nodes {
+            if child == nil {
+                continue
+            }
+            visitedChild, err := visit(child)
+            if err != nil {
+                return nil, err
+            }
+            visitedNodes[i] = visitedChild
+        }
+        return visitedNodes, nil
+    }
+
+    switch typedNode := node.(type) {
+    case wrappedNode:
+        if typedNode.node != nil {
+            child, err := visit(typedNode.node)
+            if err != nil {
+                return nil, err
+            }
+            typedNode.node = child
+        }
+        return typedNode, nil
+    case wrappedNodes:
+        nodes, err := visitAll(typedNode.nodes)
+        if err != nil {
+            return nil, err
+        }
+        typedNode.nodes = nodes
+        return typedNode, nil
+    case manyWrappedNodes:
+        nodeLists := make([][]Node, len(typedNode.nodeLists))
+        for i, nodeList := range typedNode.nodeLists {
+            nodes, err := visitAll(nodeList)
+            if err != nil {
+                return nil, err
+            }
+            nodeLists[i] = nodes
+        }
+        typedNode.nodeLists = nodeLists
+        return typedNode, nil
+    case fixedSizeWrappedNode:
+        inner, err := rebuildChildren(typedNode.node, visit)
+        if err != nil {
+            return nil, err
+        }
+        typedNode.node = inner.(manyWrappedNodes)
+        return typedNode, nil
+    }
+    return node, nil
+}
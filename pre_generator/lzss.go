@@ -0,0 +1,164 @@
+package pre_generator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Compressed pre items use the classic LZSS scheme (Haruhiko Okumura's LZSS.C), which is what the game decodes:
+//
+//   - A flag byte precedes every 8 tokens, read from its lowest bit up.
+//   - A set bit means the token is a literal byte.
+//   - A clear bit means the token is a 2-byte reference to earlier output in a 4096-byte ring buffer:
+//     12 bits of position, then 4 bits of length (minus 3).
+//
+// The ring buffer starts off filled with spaces, and output starts being written at position N-F.
+const (
+	lzss_N         = 4096 // Size of the ring buffer
+	lzss_F         = 18   // Longest match
+	lzss_Threshold = 2    // Matches must be longer than this to be encoded as a reference
+
+	lzss_MaxChainLength = 256 // How many earlier positions to try when looking for a match
+	lzss_HashSize       = 1 << 16
+)
+
+func CompressLzss(data []byte) []byte {
+	output := make([]byte, 0, len(data)+len(data)/8+1)
+
+	// Candidates for matches are found through chains of earlier positions that start with the same 3 bytes
+	head := make([]int32, lzss_HashSize)
+	for i := range head {
+		head[i] = -1
+	}
+	previous := make([]int32, len(data))
+
+	hashAt := func(position int) int {
+		return (int(data[position])<<8 ^ int(data[position+1])<<4 ^ int(data[position+2])) & (lzss_HashSize - 1)
+	}
+
+	insert := func(position int) {
+		if position+lzss_Threshold >= len(data) {
+			return
+		}
+		hash := hashAt(position)
+		previous[position] = head[hash]
+		head[hash] = int32(position)
+	}
+
+	flagIndex := 0
+	flagBit := uint(8)
+
+	nextToken := func() {
+		if flagBit == 8 {
+			output = append(output, 0)
+			flagIndex = len(output) - 1
+			flagBit = 0
+		}
+		flagBit++
+	}
+
+	position := 0
+	for position < len(data) {
+		bestLength := 0
+		bestPosition := 0
+
+		if position+lzss_Threshold < len(data) {
+			longestPossible := len(data) - position
+			if longestPossible > lzss_F {
+				longestPossible = lzss_F
+			}
+
+			// Only earlier output is referenced (never the initial spaces), so decoders don't need to agree on how
+			// the ring buffer starts off.
+			candidate := int(head[hashAt(position)])
+			for chain := 0; candidate >= 0 && chain < lzss_MaxChainLength; chain++ {
+				if position-candidate > lzss_N-lzss_F {
+					break
+				}
+				length := 0
+				for length < longestPossible && data[candidate+length] == data[position+length] {
+					length++
+				}
+				if length > bestLength {
+					bestLength = length
+					bestPosition = candidate
+					if length == longestPossible {
+						break
+					}
+				}
+				candidate = int(previous[candidate])
+			}
+		}
+
+		nextToken()
+		if bestLength > lzss_Threshold {
+			ringPosition := (lzss_N - lzss_F + bestPosition) % lzss_N
+			output = append(output,
+				byte(ringPosition),
+				byte((ringPosition>>4)&0xF0)|byte(bestLength-(lzss_Threshold+1)))
+		} else {
+			bestLength = 1
+			output[flagIndex] |= 1 << (flagBit - 1)
+			output = append(output, data[position])
+		}
+
+		for i := 0; i < bestLength; i++ {
+			insert(position)
+			position++
+		}
+	}
+
+	return output
+}
+
+func DecompressLzss(data []byte, inflatedSize int) ([]byte, error) {
+	output := make([]byte, 0, inflatedSize)
+
+	var ringBuffer [lzss_N]byte
+	for i := 0; i < lzss_N-lzss_F; i++ {
+		ringBuffer[i] = ' '
+	}
+	r := lzss_N - lzss_F
+
+	index := 0
+	flags := uint(0)
+	for len(output) < inflatedSize {
+		flags >>= 1
+		if flags&0x100 == 0 {
+			if index >= len(data) {
+				break
+			}
+			flags = uint(data[index]) | 0xFF00
+			index++
+		}
+
+		if flags&1 != 0 {
+			if index >= len(data) {
+				break
+			}
+			output = append(output, data[index])
+			ringBuffer[r] = data[index]
+			r = (r + 1) % lzss_N
+			index++
+			continue
+		}
+
+		if index+1 >= len(data) {
+			break
+		}
+		position := int(data[index]) | int(data[index+1]&0xF0)<<4
+		length := int(data[index+1]&0x0F) + lzss_Threshold + 1
+		index += 2
+		for k := 0; k < length && len(output) < inflatedSize; k++ {
+			c := ringBuffer[(position+k)%lzss_N]
+			output = append(output, c)
+			ringBuffer[r] = c
+			r = (r + 1) % lzss_N
+		}
+	}
+
+	if len(output) != inflatedSize {
+		return output, errors.New(fmt.Sprintf("Compressed data ended after %d of %d bytes", len(output), inflatedSize))
+	}
+	return output, nil
+}
@@ -0,0 +1,146 @@
+package pre_generator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func syntheticData() map[string][]byte {
+	random := rand.New(rand.NewSource(1))
+
+	randomBytes := make([]byte, 20000)
+	random.Read(randomBytes)
+
+	fewSymbols := make([]byte, 50000)
+	for i := range fewSymbols {
+		fewSymbols[i] = "abc"[random.Intn(3)]
+	}
+
+	// Long enough that matches have to come from the far end of the ring buffer
+	longPeriod := make([]byte, 30000)
+	period := randomBytes[:lzss_N-lzss_F]
+	for i := range longPeriod {
+		longPeriod[i] = period[i%len(period)]
+	}
+
+	return map[string][]byte{
+		"empty":       {},
+		"one byte":    {0x42},
+		"short":       []byte("hi"),
+		"spaces":      bytes.Repeat([]byte(" "), 5000),
+		"zeroes":      make([]byte, 70000),
+		"text":        []byte(strings.Repeat("script Foo\n    Bar x = 1 y = \"hello\"\nendscript\n", 500)),
+		"random":      randomBytes,
+		"few symbols": fewSymbols,
+		"long period": longPeriod,
+	}
+}
+
+func TestLzssRoundTrip(t *testing.T) {
+	for name, data := range syntheticData() {
+		compressed := CompressLzss(data)
+		decompressed, err := DecompressLzss(compressed, len(data))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Errorf("%s: decompressed data doesn't match the original", name)
+		}
+	}
+}
+
+func TestLzssCompressesRepetitiveData(t *testing.T) {
+	data := syntheticData()["text"]
+	compressed := CompressLzss(data)
+	if len(compressed) >= len(data)/4 {
+		t.Errorf("expected %d bytes to compress well, got %d bytes", len(data), len(compressed))
+	}
+}
+
+func TestLzssDecodesReferencesToInitialSpaces(t *testing.T) {
+	// A flag byte for 1 reference, then a reference to 18 bytes of the ring buffer before anything was written
+	compressed := []byte{0x00, 0x00, 0x0F}
+	decompressed, err := DecompressLzss(compressed, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decompressed) != strings.Repeat(" ", 18) {
+		t.Errorf("expected 18 spaces, got %q", decompressed)
+	}
+}
+
+func TestLzssReportsTruncatedData(t *testing.T) {
+	data := syntheticData()["random"]
+	compressed := CompressLzss(data)
+	if _, err := DecompressLzss(compressed[:len(compressed)/2], len(data)); err == nil {
+		t.Error("expected an error for truncated data")
+	}
+}
+
+func TestPreRoundTrip(t *testing.T) {
+	directory := t.TempDir()
+
+	var preSpec PreSpec
+	data := syntheticData()
+	var names []string
+	for name := range data {
+		names = append(names, name)
+	}
+
+	for i, name := range names {
+		pathOnDisk := filepath.Join(directory, strings.Replace(name, " ", "_", -1)+".bin")
+		if err := ioutil.WriteFile(pathOnDisk, data[name], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// A mix of global and per-item compression settings
+		compression := []Compression{Compression_Default, Compression_None, Compression_Lzss}[i%3]
+		preSpec = append(preSpec, PreSpecItem{
+			PathOnDisk:    pathOnDisk,
+			PathInsidePre: "data\\" + filepath.Base(pathOnDisk),
+			Compression:   compression,
+		})
+	}
+
+	for _, compressByDefault := range []bool{false, true} {
+		preBytes, err := MakePreWithSettings(preSpec, PreSettings{Compress: compressByDefault})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pre, err := ReadPre(preBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pre.Items) != len(preSpec) {
+			t.Fatalf("expected %d items, got %d", len(preSpec), len(pre.Items))
+		}
+
+		for i, item := range pre.Items {
+			name := names[i]
+			shouldCompress := preSpec[i].Compression == Compression_Lzss || (preSpec[i].Compression == Compression_Default && compressByDefault)
+			if item.IsCompressed() && !shouldCompress {
+				t.Errorf("%s: compressed when it shouldn't be", name)
+			}
+			if !item.IsCompressed() && shouldCompress && len(CompressLzss(data[name])) < len(data[name]) {
+				t.Errorf("%s: not compressed when it should be", name)
+			}
+			if item.PathInsidePre != preSpec[i].PathInsidePre || !item.HasValidChecksum() {
+				t.Errorf("%s: unexpected path '%s' (checksum 0x%08x)", name, item.PathInsidePre, item.PathInsidePreChecksum)
+			}
+
+			contents, err := item.Contents()
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			if !bytes.Equal(contents, data[name]) {
+				t.Errorf("%s: contents don't match the original", name)
+			}
+		}
+	}
+}
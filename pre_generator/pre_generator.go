@@ -1,132 +1,96 @@
 package pre_generator
 
 import (
-	"encoding/binary"
-	"github.com/byxor/NeverScript/compiler"
-	"io/ioutil"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
-type PreSpec []PreSpecItem
-type PreSpecItem struct {
-	PathOnDisk    string
-	PathInsidePre string
-}
-
-func ParsePreSpec(preSpecPath string) (preSpec []PreSpecItem) {
-	fileBytes, err := ioutil.ReadFile(preSpecPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	text := strings.Replace(string(fileBytes), "\r", "", -1)
-	lines := strings.Split(text, "\n")
+type Compression int
 
-	// Parser has 2 states
-	var state int
-	state_readingPathOnDisk := 0
-	state_readingPathInsidePre := 1
+const (
+	Compression_Default Compression = iota // Use PreSettings.Compress
+	Compression_None
+	Compression_Lzss
+)
 
-	// Parse each item in the pre spec
-	var pathOnDisk string
-	var pathInsidePre string
-	for _, line := range lines {
-		switch state {
-		case state_readingPathOnDisk:
-			if line == "" {
-				continue
-			}
-			pathOnDisk = line
-			state = state_readingPathInsidePre
-		case state_readingPathInsidePre:
-			pathInsidePre = line
-			preSpec = append(preSpec, PreSpecItem{
-				PathOnDisk:    pathOnDisk,
-				PathInsidePre: pathInsidePre,
-			})
-			state = state_readingPathOnDisk
-		default:
-			log.Println("Reached unexpected state when parsing pre spec")
-			break
-		}
-	}
+type PreSettings struct {
+	Compress bool // Whether items with Compression_Default are compressed
 
-	return preSpec
+	// Turns .ns items into QB bytecode, so source code can be packed without compiling it first.
+	Compile func(nsFilePath string) ([]byte, error)
 }
 
-func MakePre(preSpec PreSpec) []byte {
-	pre := make([]byte, 25000000) // FIXME(brandon): Arbitrarily-sized buffer, could crash if low on RAM or if Pre is too large.
+func MakePre(preSpec PreSpec) ([]byte, error) {
+	return MakePreWithSettings(preSpec, PreSettings{})
+}
 
-	var globalHeader struct {
-		Size          uint32
-		Version       uint32
-		NumberOfItems uint32
+// Builds the whole pre in memory. Prefer MakePreFile for big pres.
+func MakePreWithSettings(preSpec PreSpec, settings PreSettings) ([]byte, error) {
+	var buffer memoryWriteSeeker
+	if err := WritePre(&buffer, preSpec, settings); err != nil {
+		return nil, err
 	}
-	globalHeader.Version = 0xABCD0003
-
-	offset := uint32(12)
-	for _, preSpecItem := range preSpec {
-		// Read next pre item into memory
-		fileBytes, err := ioutil.ReadFile(preSpecItem.PathOnDisk)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fileLength := uint32(len(fileBytes))
+	return buffer.Bytes, nil
+}
 
-		// Calculate header values
-		var preItemHeader struct {
-			InflatedSize          uint32
-			DeflatedSize          uint32
-			PathInsidePreLength   uint32
-			PathInsidePre         string
-			PathInsidePreChecksum uint32
-		}
-		preItemHeader.InflatedSize = uint32(len(fileBytes))
-		preItemHeader.DeflatedSize = 0
-		preItemHeader.PathInsidePreLength = uint32(len(preSpecItem.PathInsidePre) + 1)
-		preItemHeader.PathInsidePre = preSpecItem.PathInsidePre
-		preItemHeader.PathInsidePreChecksum = compiler.StringToChecksum(preSpecItem.PathInsidePre)
+// Streams the pre to a file, reading one item at a time.
+// The pre is written to a temporary file first, so an existing file is left alone if anything goes wrong.
+func MakePreFile(prePath string, preSpec PreSpec, settings PreSettings) error {
+	return writePreFile(prePath, func(output io.WriteSeeker) error {
+		return WritePre(output, preSpec, settings)
+	})
+}
 
-		// Align end of pathInsidePre string with nearest 4th byte
-		x := offset + 12 + preItemHeader.PathInsidePreLength
-		for x%4 != 0 {
-			x++
-		}
-		preItemHeader.PathInsidePreLength = x - offset - 12 // A little bit of algebra
+func writePreFile(prePath string, write func(output io.WriteSeeker) error) error {
+	temporaryPath := prePath + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		return err
+	}
+	return os.Rename(temporaryPath, prePath)
+}
 
-		// Write header
-		binary.LittleEndian.PutUint32(pre[offset:], preItemHeader.InflatedSize)
-		binary.LittleEndian.PutUint32(pre[offset+4:], preItemHeader.DeflatedSize)
-		binary.LittleEndian.PutUint32(pre[offset+8:], preItemHeader.PathInsidePreLength)
-		binary.LittleEndian.PutUint32(pre[offset+12:], preItemHeader.PathInsidePreChecksum)
-		offset += 16
-		copy(pre[offset:], preItemHeader.PathInsidePre)
-		offset += preItemHeader.PathInsidePreLength
-		pre[offset-1] = 0
+func WritePre(output io.WriteSeeker, preSpec PreSpec, settings PreSettings) error {
+	writer, err := NewPreWriter(output)
+	if err != nil {
+		return err
+	}
+	for _, preSpecItem := range preSpec {
+		compress := preSpecItem.Compression == Compression_Lzss || (preSpecItem.Compression == Compression_Default && settings.Compress)
 
-		// Align offset with nearest 4th byte
-		for offset%4 != 0 {
-			offset++
+		if IsNsFile(preSpecItem.PathOnDisk) {
+			if settings.Compile == nil {
+				return errors.New(fmt.Sprintf("Can't pack '%s' without compiling it", preSpecItem.PathOnDisk))
+			}
+			qb, err := settings.Compile(preSpecItem.PathOnDisk)
+			if err != nil {
+				return err
+			}
+			if err := writer.WriteItem(preSpecItem.PathInsidePre, qb, compress); err != nil {
+				return err
+			}
+			continue
 		}
 
-		// Write pre item contents
-		copy(pre[offset:], fileBytes)
-		offset += fileLength
-
-		// Align offset with nearest 4th byte
-		for offset%4 != 0 {
-			offset++
+		if err := writer.WriteFile(preSpecItem.PathOnDisk, preSpecItem.PathInsidePre, compress); err != nil {
+			return err
 		}
-
-		// Update global header data
-		globalHeader.NumberOfItems++
 	}
-	globalHeader.Size = offset
-
-	// Write global header at start of pre (now that we have all the necessary info)
-	binary.LittleEndian.PutUint32(pre, globalHeader.Size)
-	binary.LittleEndian.PutUint32(pre[4:], globalHeader.Version)
-	binary.LittleEndian.PutUint32(pre[8:], globalHeader.NumberOfItems)
+	return writer.Close()
+}
 
-	return pre[:offset]
+func IsNsFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".ns")
 }
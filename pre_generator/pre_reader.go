@@ -0,0 +1,168 @@
+package pre_generator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/byxor/NeverScript/compiler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	PreVersion        = 0xABCD0003
+	preHeaderSize     = 12
+	preItemHeaderSize = 16
+)
+
+type Pre struct {
+	Size    uint32
+	Version uint32
+	Items   []PreItem
+}
+
+type PreItem struct {
+	PathInsidePre         string
+	PathInsidePreChecksum uint32
+	InflatedSize          uint32
+	DeflatedSize          uint32 // 0 if the item isn't compressed
+	Offset                uint32 // Where the item's header starts in the pre
+	Header                []byte // The item's header and path, exactly as they're stored in the pre
+	Data                  []byte // The item's bytes as they're stored in the pre (compressed if DeflatedSize isn't 0)
+}
+
+func (item PreItem) IsCompressed() bool {
+	return item.DeflatedSize != 0
+}
+
+// The item's bytes, decompressed if necessary.
+func (item PreItem) Contents() ([]byte, error) {
+	if !item.IsCompressed() {
+		return item.Data, nil
+	}
+	contents, err := DecompressLzss(item.Data, int(item.InflatedSize))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't decompress '%s': %s", item.PathInsidePre, err))
+	}
+	return contents, nil
+}
+
+// Whether the checksum stored in the item's header matches its path.
+func (item PreItem) HasValidChecksum() bool {
+	return item.PathInsidePreChecksum == compiler.StringToChecksum(item.PathInsidePre)
+}
+
+func ReadPreFile(prePath string) (Pre, error) {
+	fileBytes, err := ioutil.ReadFile(prePath)
+	if err != nil {
+		return Pre{}, err
+	}
+	return ReadPre(fileBytes)
+}
+
+// Parses the layout written by MakePre: a 12-byte global header, then each item's 16-byte header, its path and its
+// contents, all aligned to 4 bytes.
+func ReadPre(pre []byte) (Pre, error) {
+	if len(pre) < preHeaderSize {
+		return Pre{}, errors.New(fmt.Sprintf("Pre is too small to have a header (%d bytes)", len(pre)))
+	}
+
+	result := Pre{
+		Size:    binary.LittleEndian.Uint32(pre),
+		Version: binary.LittleEndian.Uint32(pre[4:]),
+	}
+	numberOfItems := binary.LittleEndian.Uint32(pre[8:])
+
+	if result.Version != PreVersion {
+		return result, errors.New(fmt.Sprintf("Unsupported pre version 0x%08X (expected 0x%08X)", result.Version, uint32(PreVersion)))
+	}
+	if int(result.Size) > len(pre) {
+		return result, errors.New(fmt.Sprintf("Pre header says it's %d bytes, but only %d bytes were given", result.Size, len(pre)))
+	}
+
+	align := func(offset uint32) uint32 {
+		for offset%4 != 0 {
+			offset++
+		}
+		return offset
+	}
+
+	offset := uint32(preHeaderSize)
+	for i := uint32(0); i < numberOfItems; i++ {
+		if offset+preItemHeaderSize > result.Size {
+			return result, errors.New(fmt.Sprintf("Item %d's header at 0x%x runs past the end of the pre", i, offset))
+		}
+
+		item := PreItem{
+			InflatedSize:          binary.LittleEndian.Uint32(pre[offset:]),
+			DeflatedSize:          binary.LittleEndian.Uint32(pre[offset+4:]),
+			PathInsidePreChecksum: binary.LittleEndian.Uint32(pre[offset+12:]),
+			Offset:                offset,
+		}
+		pathInsidePreLength := binary.LittleEndian.Uint32(pre[offset+8:])
+
+		pathStart := offset + preItemHeaderSize
+		if uint64(pathStart)+uint64(pathInsidePreLength) > uint64(result.Size) {
+			return result, errors.New(fmt.Sprintf("Item %d's path at 0x%x runs past the end of the pre", i, pathStart))
+		}
+		path := pre[pathStart : pathStart+pathInsidePreLength]
+		if end := strings.IndexByte(string(path), 0); end >= 0 {
+			path = path[:end]
+		}
+		item.PathInsidePre = string(path)
+
+		dataSize := item.InflatedSize
+		if item.IsCompressed() {
+			dataSize = item.DeflatedSize
+		}
+		dataStart := align(pathStart + pathInsidePreLength)
+		if uint64(dataStart)+uint64(dataSize) > uint64(result.Size) {
+			return result, errors.New(fmt.Sprintf("Item '%s' at 0x%x runs past the end of the pre", item.PathInsidePre, offset))
+		}
+		item.Header = pre[offset:dataStart]
+		item.Data = pre[dataStart : dataStart+dataSize]
+
+		result.Items = append(result.Items, item)
+		offset = align(dataStart + dataSize)
+	}
+
+	return result, nil
+}
+
+// Writes each item of the pre to outputDirectory (decompressing them if necessary), using the path inside the pre.
+// Items with checksums that don't match their paths are reported as warnings.
+func ExtractPre(pre Pre, outputDirectory string) (warnings []string, err error) {
+	for _, item := range pre.Items {
+		if !item.HasValidChecksum() {
+			warnings = append(warnings, fmt.Sprintf("'%s' has checksum 0x%08x, expected 0x%08x", item.PathInsidePre, item.PathInsidePreChecksum, compiler.StringToChecksum(item.PathInsidePre)))
+		}
+
+		contents, err := item.Contents()
+		if err != nil {
+			return warnings, err
+		}
+
+		pathOnDisk, err := PathOnDisk(item.PathInsidePre, outputDirectory)
+		if err != nil {
+			return warnings, err
+		}
+		if err := os.MkdirAll(filepath.Dir(pathOnDisk), 0755); err != nil {
+			return warnings, err
+		}
+		if err := ioutil.WriteFile(pathOnDisk, contents, 0644); err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+// Where a path inside a pre (e.g. "scripts\game\game.qb") should go when extracted to outputDirectory.
+func PathOnDisk(pathInsidePre string, outputDirectory string) (string, error) {
+	relativePath := filepath.Clean(filepath.FromSlash(strings.Replace(pathInsidePre, "\\", "/", -1)))
+	if relativePath == "." || filepath.IsAbs(relativePath) || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("Refusing to extract '%s' outside of '%s'", pathInsidePre, outputDirectory))
+	}
+	return filepath.Join(outputDirectory, relativePath), nil
+}
@@ -0,0 +1,162 @@
+package pre_generator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/byxor/NeverScript/compiler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Makes a pre out of files with the given paths inside the pre and contents.
+func makePreForTest(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+	directory := t.TempDir()
+	var preSpec PreSpec
+	for i, file := range files {
+		pathOnDisk := filepath.Join(directory, string(rune('a'+i)))
+		if err := ioutil.WriteFile(pathOnDisk, []byte(file[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		preSpec = append(preSpec, PreSpecItem{PathOnDisk: pathOnDisk, PathInsidePre: file[0]})
+	}
+	pre, err := MakePre(preSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pre
+}
+
+func expectPreError(t *testing.T, pre []byte, expectedError string) {
+	t.Helper()
+	_, err := ReadPre(pre)
+	if err == nil {
+		t.Fatalf("Expected '%s', but the pre was read", expectedError)
+	}
+	if err.Error() != expectedError {
+		t.Fatalf("Expected '%s', got '%s'", expectedError, err.Error())
+	}
+}
+
+func TestReadPre(t *testing.T) {
+	pre, err := ReadPre(makePreForTest(t,
+		[2]string{"scripts\\game\\game.qb", "game script"},
+		[2]string{"levels\\foo.qb", "level"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre.Version != PreVersion || len(pre.Items) != 2 {
+		t.Fatalf("Unexpected pre: version 0x%08x, %d items", pre.Version, len(pre.Items))
+	}
+	for i, expected := range [][2]string{{"scripts\\game\\game.qb", "game script"}, {"levels\\foo.qb", "level"}} {
+		item := pre.Items[i]
+		if item.PathInsidePre != expected[0] || string(item.Data) != expected[1] {
+			t.Errorf("Item %d is '%s' containing %q, expected '%s' containing %q", i, item.PathInsidePre, item.Data, expected[0], expected[1])
+		}
+		if item.IsCompressed() || !item.HasValidChecksum() {
+			t.Errorf("Item %d should be uncompressed with a valid checksum", i)
+		}
+	}
+}
+
+func TestReadPreErrors(t *testing.T) {
+	pre := makePreForTest(t, [2]string{"foo.qb", "contents"})
+	itemOffset := uint32(preHeaderSize)
+	pathOffset := itemOffset + preItemHeaderSize
+	dataOffset := uint32(len(pre) - len("contents"))
+
+	expectPreError(t, pre[:8], "Pre is too small to have a header (8 bytes)")
+
+	badVersion := append([]byte{}, pre...)
+	binary.LittleEndian.PutUint32(badVersion[4:], 0xABCD0002)
+	expectPreError(t, badVersion, "Unsupported pre version 0xABCD0002 (expected 0xABCD0003)")
+
+	expectPreError(t, pre[:len(pre)-1], fmt.Sprintf("Pre header says it's %d bytes, but only %d bytes were given", len(pre), len(pre)-1))
+
+	// Lowering the size in the header cuts off the item wherever we like
+	truncated := func(size uint32) []byte {
+		truncatedPre := append([]byte{}, pre[:size]...)
+		binary.LittleEndian.PutUint32(truncatedPre, size)
+		return truncatedPre
+	}
+	expectPreError(t, truncated(pathOffset-1), fmt.Sprintf("Item 0's header at 0x%x runs past the end of the pre", itemOffset))
+	expectPreError(t, truncated(pathOffset+2), fmt.Sprintf("Item 0's path at 0x%x runs past the end of the pre", pathOffset))
+	expectPreError(t, truncated(dataOffset+2), fmt.Sprintf("Item 'foo.qb' at 0x%x runs past the end of the pre", itemOffset))
+
+	moreItems := append([]byte{}, pre...)
+	binary.LittleEndian.PutUint32(moreItems[8:], 2)
+	expectPreError(t, moreItems, fmt.Sprintf("Item 1's header at 0x%x runs past the end of the pre", len(pre)))
+}
+
+func TestExtractPre(t *testing.T) {
+	preBytes := makePreForTest(t,
+		[2]string{"scripts\\game\\game.qb", "game script"},
+		[2]string{"foo.qb", "foo"},
+	)
+	pre, err := ReadPre(preBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Break the checksum of the second item's path
+	binary.LittleEndian.PutUint32(preBytes[pre.Items[1].Offset+12:], 0x12345678)
+	if pre, err = ReadPre(preBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	directory := t.TempDir()
+	warnings, err := ExtractPre(pre, directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedWarning := fmt.Sprintf("'foo.qb' has checksum 0x12345678, expected 0x%08x", compiler.StringToChecksum("foo.qb"))
+	if len(warnings) != 1 || warnings[0] != expectedWarning {
+		t.Fatalf("Expected warnings %q, got %q", []string{expectedWarning}, warnings)
+	}
+
+	for path, expected := range map[string]string{"scripts/game/game.qb": "game script", "foo.qb": "foo"} {
+		contents, err := ioutil.ReadFile(filepath.Join(directory, filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(contents, []byte(expected)) {
+			t.Errorf("%s contains %q, expected %q", path, contents, expected)
+		}
+	}
+}
+
+func TestExtractPreRefusesToLeaveOutputDirectory(t *testing.T) {
+	pre, err := ReadPre(makePreForTest(t, [2]string{"..\\..\\evil.qb", "evil"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := filepath.Join(t.TempDir(), "a", "b")
+	if _, err := ExtractPre(pre, directory); err == nil {
+		t.Fatal("Expected an error when extracting outside of the output directory")
+	}
+	if _, err := os.Stat(filepath.Join(directory, "..", "..", "evil.qb")); !os.IsNotExist(err) {
+		t.Fatal("evil.qb was written outside of the output directory")
+	}
+}
+
+func TestPathOnDisk(t *testing.T) {
+	pathOnDisk, err := PathOnDisk("scripts\\game\\game.qb", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join("out", "scripts", "game", "game.qb"); pathOnDisk != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, pathOnDisk)
+	}
+
+	for _, pathInsidePre := range []string{"..", "../x.qb", "..\\..\\x.qb", "a/../../x.qb", "/etc/passwd", "\\etc\\passwd", ".", ""} {
+		if pathOnDisk, err := PathOnDisk(pathInsidePre, "out"); err == nil {
+			t.Errorf("Expected '%s' to be refused, got '%s'", pathInsidePre, pathOnDisk)
+		} else if expected := fmt.Sprintf("Refusing to extract '%s' outside of 'out'", pathInsidePre); err.Error() != expected {
+			t.Errorf("Expected '%s', got '%s'", expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package pre_generator
+
+import (
+	"errors"
+	"fmt"
+	"github.com/byxor/NeverScript/compiler"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type RepackResult struct {
+	Replaced []string // Paths inside the pre whose contents were replaced
+	Added    []string // Paths inside the pre that weren't there before
+}
+
+// Treats every file inside a directory as an override for the pre, e.g. "overrides/qb/game.qb" overrides
+// "qb\game.qb". Files are packed as they are.
+func OverridesFromDirectory(directory string) (PreSpec, error) {
+	var overrides PreSpec
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		overrides = append(overrides, PreSpecItem{
+			PathOnDisk:    path,
+			PathInsidePre: joinPathInsidePre("", relativePath),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		return overrides[i].PathInsidePre < overrides[j].PathInsidePre
+	})
+	return overrides, nil
+}
+
+// Writes a copy of the pre with its items replaced by the overrides that share their path (ignoring case and slash
+// direction). Overrides that don't match an item are added at the end. Items that aren't overridden are copied
+// byte-for-byte.
+//
+// Replaced items are compressed if the items they replace were. Added items follow settings.Compress unless they
+// say otherwise.
+func RepackPre(output io.WriteSeeker, pre Pre, overrides PreSpec, settings PreSettings) (RepackResult, error) {
+	var result RepackResult
+
+	normalise := func(pathInsidePre string) string {
+		return strings.ToLower(strings.Replace(pathInsidePre, "/", "\\", -1))
+	}
+
+	overridesByPath := make(map[string]PreSpecItem)
+	for _, override := range overrides {
+		overridesByPath[normalise(override.PathInsidePre)] = override
+	}
+
+	writer, err := NewPreWriter(output)
+	if err != nil {
+		return result, err
+	}
+
+	usedOverrides := make(map[string]bool)
+	for _, item := range pre.Items {
+		path := normalise(item.PathInsidePre)
+		override, found := overridesByPath[path]
+		if !found {
+			if err := writer.WriteRawItem(item); err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		// Keep the item's original spelling of its path
+		if err := writer.WriteFile(override.PathOnDisk, item.PathInsidePre, item.IsCompressed()); err != nil {
+			return result, err
+		}
+		usedOverrides[path] = true
+		result.Replaced = append(result.Replaced, item.PathInsidePre)
+	}
+
+	// Added items can't share a checksum with anything else in the pre
+	pathsByChecksum := make(map[uint32]string)
+	for _, item := range pre.Items {
+		pathsByChecksum[compiler.StringToChecksum(item.PathInsidePre)] = item.PathInsidePre
+	}
+	var additions PreSpec
+	for _, override := range overrides {
+		if usedOverrides[normalise(override.PathInsidePre)] {
+			continue
+		}
+		checksum := compiler.StringToChecksum(override.PathInsidePre)
+		if other, found := pathsByChecksum[checksum]; found {
+			return result, errors.New(fmt.Sprintf("Can't add '%s' because it has the same checksum (0x%08x) as '%s'", override.PathInsidePre, checksum, other))
+		}
+		pathsByChecksum[checksum] = override.PathInsidePre
+		additions = append(additions, override)
+	}
+
+	for _, addition := range additions {
+		compress := addition.Compression == Compression_Lzss || (addition.Compression == Compression_Default && settings.Compress)
+		if err := writer.WriteFile(addition.PathOnDisk, addition.PathInsidePre, compress); err != nil {
+			return result, err
+		}
+		result.Added = append(result.Added, addition.PathInsidePre)
+	}
+
+	return result, writer.Close()
+}
+
+// Repacks a pre file using the files inside overridesDirectory. The input and output can be the same file.
+func RepackPreFile(inputPath string, overridesDirectory string, outputPath string, settings PreSettings) (RepackResult, error) {
+	pre, err := ReadPreFile(inputPath)
+	if err != nil {
+		return RepackResult{}, err
+	}
+	overrides, err := OverridesFromDirectory(overridesDirectory)
+	if err != nil {
+		return RepackResult{}, err
+	}
+
+	var result RepackResult
+	err = writePreFile(outputPath, func(output io.WriteSeeker) error {
+		result, err = RepackPre(output, pre, overrides, settings)
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,111 @@
+package pre_generator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepackPre(t *testing.T) {
+	directory := t.TempDir()
+	writeFiles(t, directory, "original/a.qb", "original/b.qb", "original/c.txt")
+	if err := ioutil.WriteFile(filepath.Join(directory, "original", "b.qb"), bytes.Repeat([]byte("original "), 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	preSpec := PreSpec{
+		{PathOnDisk: filepath.Join(directory, "original", "a.qb"), PathInsidePre: "qb\\a.qb"},
+		{PathOnDisk: filepath.Join(directory, "original", "b.qb"), PathInsidePre: "qb\\B.qb", Compression: Compression_Lzss},
+		{PathOnDisk: filepath.Join(directory, "original", "c.txt"), PathInsidePre: "c.txt"},
+	}
+	originalBytes, err := MakePre(preSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ReadPre(originalBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overridesDirectory := filepath.Join(directory, "overrides")
+	writeFiles(t, overridesDirectory, "qb/b.qb", "new/d.qb")
+	if err := ioutil.WriteFile(filepath.Join(overridesDirectory, "qb", "b.qb"), bytes.Repeat([]byte("replaced "), 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := OverridesFromDirectory(overridesDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output memoryWriteSeeker
+	result, err := RepackPre(&output, original, overrides, PreSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Replaced) != 1 || result.Replaced[0] != "qb\\B.qb" || len(result.Added) != 1 || result.Added[0] != "new\\d.qb" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	repacked, err := ReadPre(output.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repacked.Items) != 4 {
+		t.Fatalf("expected 4 items, got %d", len(repacked.Items))
+	}
+
+	// Untouched items are byte-identical
+	for _, i := range []int{0, 2} {
+		if !bytes.Equal(repacked.Items[i].Header, original.Items[i].Header) || !bytes.Equal(repacked.Items[i].Data, original.Items[i].Data) {
+			t.Errorf("'%s' changed", original.Items[i].PathInsidePre)
+		}
+	}
+
+	replaced := repacked.Items[1]
+	contents, err := replaced.Contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.PathInsidePre != "qb\\B.qb" || !replaced.IsCompressed() || !bytes.Equal(contents, bytes.Repeat([]byte("replaced "), 100)) {
+		t.Errorf("unexpected replaced item '%s' (compressed: %t): %q", replaced.PathInsidePre, replaced.IsCompressed(), contents)
+	}
+
+	added := repacked.Items[3]
+	if added.PathInsidePre != "new\\d.qb" || string(added.Data) != "new/d.qb" || !added.HasValidChecksum() {
+		t.Errorf("unexpected added item '%s': %q", added.PathInsidePre, added.Data)
+	}
+}
+
+func TestRepackPreWithoutOverridesIsIdentical(t *testing.T) {
+	pre := syntheticPre(t)
+
+	var output memoryWriteSeeker
+	parsed, err := ReadPre(pre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RepackPre(&output, parsed, nil, PreSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output.Bytes, pre) {
+		t.Error("repacking without overrides changed the pre")
+	}
+}
+
+func syntheticPre(t *testing.T) []byte {
+	directory := t.TempDir()
+	var preSpec PreSpec
+	for name, data := range syntheticData() {
+		pathOnDisk := filepath.Join(directory, name)
+		if err := ioutil.WriteFile(pathOnDisk, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		preSpec = append(preSpec, PreSpecItem{PathOnDisk: pathOnDisk, PathInsidePre: "data\\" + name})
+	}
+	pre, err := MakePreWithSettings(preSpec, PreSettings{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pre
+}
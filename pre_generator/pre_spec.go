@@ -0,0 +1,309 @@
+package pre_generator
+
+import (
+	"fmt"
+	"github.com/byxor/NeverScript/compiler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type PreSpec []PreSpecItem
+type PreSpecItem struct {
+	PathOnDisk    string
+	PathInsidePre string
+	Compression   Compression
+	LineNumber    int // Where the item came from in the pre spec (0 if it wasn't parsed from one)
+}
+
+type PreSpecError struct {
+	PreSpecPath string
+	LineNumber  int
+	Message     string
+}
+
+func (self PreSpecError) Error() string {
+	return fmt.Sprintf("ERROR %s(line %d) - %s", self.PreSpecPath, self.LineNumber, self.Message)
+}
+
+// Pre specs come in two formats.
+//
+// The original format alternates between lines containing the path on disk and the path inside the pre:
+//
+//     C:\mod\build\qb\_mods\byxor_debug.qb
+//     qb\_mods\byxor_debug.qb
+//
+// Specs containing "->" or comments are read in the newer format instead, which has one entry per line:
+//
+//     # Comments start with a '#'.
+//     build\qdir.txt        -> code\qb\qdir.txt
+//     build\scripts\        -> qb\_mods\            [compress]
+//     build\levels\*\*.qb   -> qb\levels\           [nocompress]
+//
+// An entry on the left can be a file, a directory (every file inside it, recursively) or a glob. Files from
+// directories and globs keep their path relative to the directory (or the part of the glob before any wildcards),
+// appended to the path on the right. A file whose path on the right ends with a slash keeps its name.
+// Relative paths on the left are relative to the pre spec.
+//
+// Source code (.ns) is compiled when the pre is made. Unless a path inside the pre is given for it, its name inside
+// the pre ends in .qb instead.
+//
+// Either way, paths inside the pre must be unique and must have unique checksums.
+func ParsePreSpec(preSpecPath string) (PreSpec, error) {
+	fileBytes, err := ioutil.ReadFile(preSpecPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePreSpecText(string(fileBytes), preSpecPath)
+}
+
+func ParsePreSpecText(text string, preSpecPath string) (PreSpec, error) {
+	text = strings.Replace(text, "\r", "", -1)
+	lines := strings.Split(text, "\n")
+
+	isLegacy := true
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "->") || strings.HasPrefix(line, "#") {
+			isLegacy = false
+			break
+		}
+	}
+
+	var preSpec PreSpec
+	var err error
+	if !isLegacy {
+		preSpec, err = parsePreSpecEntries(lines, preSpecPath)
+	} else {
+		preSpec, err = parseLegacyPreSpec(lines, preSpecPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidatePreSpec(preSpec, preSpecPath); err != nil {
+		return nil, err
+	}
+	return preSpec, nil
+}
+
+// Makes sure no two items share a path (or a checksum) inside the pre.
+func ValidatePreSpec(preSpec PreSpec, preSpecPath string) error {
+	itemsByPath := make(map[string]PreSpecItem)
+	itemsByChecksum := make(map[uint32]PreSpecItem)
+
+	for _, item := range preSpec {
+		if item.PathInsidePre == "" {
+			return PreSpecError{preSpecPath, item.LineNumber, fmt.Sprintf("'%s' has no path inside the pre", item.PathOnDisk)}
+		}
+
+		// The game doesn't care about case or which way slashes go
+		path := strings.ToLower(strings.Replace(item.PathInsidePre, "/", "\\", -1))
+		if other, found := itemsByPath[path]; found {
+			return PreSpecError{preSpecPath, item.LineNumber, fmt.Sprintf("'%s' is already inside the pre (from '%s' on line %d)", item.PathInsidePre, other.PathOnDisk, other.LineNumber)}
+		}
+		itemsByPath[path] = item
+
+		checksum := compiler.StringToChecksum(item.PathInsidePre)
+		if other, found := itemsByChecksum[checksum]; found {
+			return PreSpecError{preSpecPath, item.LineNumber, fmt.Sprintf("'%s' has the same checksum (0x%08x) as '%s' on line %d", item.PathInsidePre, checksum, other.PathInsidePre, other.LineNumber)}
+		}
+		itemsByChecksum[checksum] = item
+	}
+
+	return nil
+}
+
+func parseLegacyPreSpec(lines []string, preSpecPath string) (preSpec PreSpec, err error) {
+	// Parser has 2 states
+	var state int
+	state_readingPathOnDisk := 0
+	state_readingPathInsidePre := 1
+
+	// Parse each item in the pre spec
+	var pathOnDisk string
+	var pathOnDiskLineNumber int
+	for i, line := range lines {
+		lineNumber := i + 1
+		switch state {
+		case state_readingPathOnDisk:
+			if line == "" {
+				continue
+			}
+			pathOnDisk = line
+			pathOnDiskLineNumber = lineNumber
+			state = state_readingPathInsidePre
+		case state_readingPathInsidePre:
+			if line == "" {
+				return nil, PreSpecError{preSpecPath, lineNumber, fmt.Sprintf("Expected the path inside the pre for '%s'", pathOnDisk)}
+			}
+			preSpec = append(preSpec, PreSpecItem{
+				PathOnDisk:    pathOnDisk,
+				PathInsidePre: line,
+				LineNumber:    pathOnDiskLineNumber,
+			})
+			state = state_readingPathOnDisk
+		}
+	}
+
+	if state == state_readingPathInsidePre {
+		return nil, PreSpecError{preSpecPath, pathOnDiskLineNumber, fmt.Sprintf("'%s' has no path inside the pre (the pre spec ended)", pathOnDisk)}
+	}
+	return preSpec, nil
+}
+
+func parsePreSpecEntries(lines []string, preSpecPath string) (PreSpec, error) {
+	baseDirectory := filepath.Dir(preSpecPath)
+
+	var preSpec PreSpec
+	for i, line := range lines {
+		lineNumber := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fail := func(format string, a ...interface{}) (PreSpec, error) {
+			return nil, PreSpecError{preSpecPath, lineNumber, fmt.Sprintf(format, a...)}
+		}
+
+		parts := strings.SplitN(line, "->", 2)
+		if len(parts) != 2 {
+			return fail("Expected '<path on disk> -> <path inside pre>'")
+		}
+		source := strings.TrimSpace(parts[0])
+		destination := strings.TrimSpace(parts[1])
+
+		// Options go in square brackets at the end of the line
+		compression := Compression_Default
+		if strings.HasSuffix(destination, "]") {
+			start := strings.LastIndex(destination, "[")
+			if start < 0 {
+				return fail("Missing '[' before the options")
+			}
+			options := strings.FieldsFunc(destination[start+1:len(destination)-1], func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t'
+			})
+			for _, option := range options {
+				switch option {
+				case "compress":
+					compression = Compression_Lzss
+				case "nocompress":
+					compression = Compression_None
+				default:
+					return fail("Unknown option '%s' (expected 'compress' or 'nocompress')", option)
+				}
+			}
+			destination = strings.TrimSpace(destination[:start])
+		}
+
+		if source == "" {
+			return fail("Expected a path on disk before '->'")
+		}
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(baseDirectory, source)
+		}
+
+		addItem := func(pathOnDisk string, pathInsidePre string) {
+			preSpec = append(preSpec, PreSpecItem{
+				PathOnDisk:    pathOnDisk,
+				PathInsidePre: pathInsidePre,
+				Compression:   compression,
+				LineNumber:    lineNumber,
+			})
+		}
+
+		// Files found through directories and globs keep their relative path
+		addFilesRelativeTo := func(directory string, pathsOnDisk []string) {
+			for _, pathOnDisk := range pathsOnDisk {
+				relativePath, _ := filepath.Rel(directory, pathOnDisk)
+				addItem(pathOnDisk, joinPathInsidePre(destination, compiledName(relativePath)))
+			}
+		}
+
+		if strings.ContainsAny(source, "*?[") {
+			matches, err := filepath.Glob(source)
+			if err != nil {
+				return fail("Invalid glob '%s': %s", source, err)
+			}
+			var files []string
+			for _, match := range matches {
+				if fileInfo, err := os.Stat(match); err == nil && fileInfo.Mode().IsRegular() {
+					files = append(files, match)
+				}
+			}
+			if len(files) == 0 {
+				return fail("'%s' didn't match any files", source)
+			}
+			addFilesRelativeTo(globBase(source), files)
+			continue
+		}
+
+		fileInfo, err := os.Stat(source)
+		if err != nil {
+			return fail("Can't find '%s'", source)
+		}
+
+		if fileInfo.IsDir() {
+			var files []string
+			err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return fail("Couldn't read directory '%s': %s", source, err)
+			}
+			if len(files) == 0 {
+				return fail("Directory '%s' has no files in it", source)
+			}
+			sort.Strings(files)
+			addFilesRelativeTo(source, files)
+			continue
+		}
+
+		if destination == "" {
+			return fail("Expected a path inside the pre after '->'")
+		}
+		if strings.HasSuffix(destination, "\\") || strings.HasSuffix(destination, "/") {
+			destination = joinPathInsidePre(destination, compiledName(filepath.Base(source)))
+		}
+		addItem(source, destination)
+	}
+
+	return preSpec, nil
+}
+
+// Joins paths inside a pre with backslashes, which is how the game writes them.
+func joinPathInsidePre(directory string, relativePath string) string {
+	relativePath = strings.Replace(filepath.ToSlash(relativePath), "/", "\\", -1)
+	directory = strings.TrimRight(directory, "\\/")
+	if directory == "" {
+		return relativePath
+	}
+	return directory + "\\" + relativePath
+}
+
+// Source code is packed as QB.
+func compiledName(path string) string {
+	if IsNsFile(path) {
+		return strings.TrimSuffix(path, filepath.Ext(path)) + ".qb"
+	}
+	return path
+}
+
+// The directory a glob starts matching from, e.g. "build/levels" for "build/levels/*/*.qb".
+func globBase(glob string) string {
+	directory := glob
+	for strings.ContainsAny(directory, "*?[") {
+		directory = filepath.Dir(directory)
+	}
+	return directory
+}
+
@@ -0,0 +1,165 @@
+package pre_generator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, directory string, paths ...string) {
+	for _, path := range paths {
+		pathOnDisk := filepath.Join(directory, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(pathOnDisk), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pathOnDisk, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectPathsInsidePre(t *testing.T, preSpec PreSpec, expected ...string) {
+	var actual []string
+	for _, item := range preSpec {
+		actual = append(actual, item.PathInsidePre)
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected paths:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func expectPreSpecError(t *testing.T, err error, lineNumber int, messageContains string) {
+	preSpecError, ok := err.(PreSpecError)
+	if !ok {
+		t.Fatalf("expected a pre spec error, got %v", err)
+	}
+	if preSpecError.LineNumber != lineNumber || !strings.Contains(preSpecError.Message, messageContains) {
+		t.Errorf("expected an error on line %d containing %q, got: %s", lineNumber, messageContains, err)
+	}
+}
+
+func TestParseLegacyPreSpec(t *testing.T) {
+	preSpec, err := ParsePreSpecText("a.qb\r\nqb\\a.qb\r\n\r\n\r\nb.qb\r\nqb\\b.qb\r\n", "spec.ps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPathsInsidePre(t, preSpec, "qb\\a.qb", "qb\\b.qb")
+	if preSpec[0].PathOnDisk != "a.qb" || preSpec[1].PathOnDisk != "b.qb" || preSpec[1].LineNumber != 5 {
+		t.Errorf("unexpected items: %+v", preSpec)
+	}
+}
+
+func TestParseLegacyPreSpecWithMissingPathInsidePre(t *testing.T) {
+	_, err := ParsePreSpecText("a.qb\nqb\\a.qb\nb.qb\n", "spec.ps")
+	expectPreSpecError(t, err, 4, "Expected the path inside the pre for 'b.qb'")
+
+	_, err = ParsePreSpecText("a.qb\nqb\\a.qb\nb.qb", "spec.ps")
+	expectPreSpecError(t, err, 3, "the pre spec ended")
+}
+
+func TestParsePreSpecEntries(t *testing.T) {
+	directory := t.TempDir()
+	writeFiles(t, directory,
+		"build/qdir.txt",
+		"build/scripts/x.qb",
+		"build/scripts/sub/y.qb",
+		"build/levels/a/l.qb",
+		"build/levels/a/l.txt",
+		"build/levels/b/m.qb",
+	)
+
+	text := strings.Join([]string{
+		"# Comments and blank lines are ignored",
+		"",
+		"build/qdir.txt -> code\\qb\\qdir.txt",
+		"build/qdir.txt -> code\\",
+		"  build/scripts   ->   qb\\_mods\\   [compress]",
+		"build/levels/*/*.qb -> qb\\levels [nocompress]",
+	}, "\n")
+
+	preSpec, err := ParsePreSpecText(text, filepath.Join(directory, "spec.ps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectPathsInsidePre(t, preSpec,
+		"code\\qb\\qdir.txt",
+		"code\\qdir.txt",
+		"qb\\_mods\\sub\\y.qb",
+		"qb\\_mods\\x.qb",
+		"qb\\levels\\a\\l.qb",
+		"qb\\levels\\b\\m.qb",
+	)
+
+	expectedCompression := []Compression{Compression_Default, Compression_Default, Compression_Lzss, Compression_Lzss, Compression_None, Compression_None}
+	expectedLineNumbers := []int{3, 4, 5, 5, 6, 6}
+	for i, item := range preSpec {
+		if item.Compression != expectedCompression[i] || item.LineNumber != expectedLineNumbers[i] {
+			t.Errorf("'%s': unexpected compression %d or line number %d", item.PathInsidePre, item.Compression, item.LineNumber)
+		}
+	}
+	if preSpec[3].PathOnDisk != filepath.Join(directory, "build", "scripts", "x.qb") {
+		t.Errorf("relative paths should be relative to the pre spec, got '%s'", preSpec[3].PathOnDisk)
+	}
+}
+
+func TestParsePreSpecEntriesErrors(t *testing.T) {
+	directory := t.TempDir()
+	writeFiles(t, directory, "a.qb", "empty/.keep")
+	os.Remove(filepath.Join(directory, "empty", ".keep"))
+	specPath := filepath.Join(directory, "spec.ps")
+
+	testCases := []struct {
+		line            string
+		messageContains string
+	}{
+		{"a.qb", "Expected '<path on disk> -> <path inside pre>'"},
+		{"-> qb\\a.qb", "Expected a path on disk"},
+		{"a.qb ->", "Expected a path inside the pre"},
+		{"missing.qb -> qb\\missing.qb", "Can't find"},
+		{"*.txt -> qb\\", "didn't match any files"},
+		{"empty -> qb\\", "has no files in it"},
+		{"a.qb -> qb\\a.qb [zip]", "Unknown option 'zip'"},
+		{"a.qb -> qb\\a.qb compress]", "Missing '['"},
+	}
+
+	for _, testCase := range testCases {
+		_, err := ParsePreSpecText("# The error is on line 2\n"+testCase.line+"\n", specPath)
+		expectPreSpecError(t, err, 2, testCase.messageContains)
+	}
+}
+
+func TestParsePreSpecFindsDuplicates(t *testing.T) {
+	directory := t.TempDir()
+	writeFiles(t, directory, "a.qb", "b.qb")
+	specPath := filepath.Join(directory, "spec.ps")
+
+	// The game ignores case and slash direction
+	_, err := ParsePreSpecText("a.qb -> qb\\A.qb\nb.qb -> QB/a.QB\n", specPath)
+	expectPreSpecError(t, err, 2, "already inside the pre")
+
+	_, err = ParsePreSpecText("a.qb\nqb\\a.qb\nb.qb\nqb\\a.qb\n", specPath)
+	expectPreSpecError(t, err, 3, "already inside the pre")
+}
+
+func TestParsePreSpecFindsChecksumCollisions(t *testing.T) {
+	directory := t.TempDir()
+	writeFiles(t, directory, "a.qb", "b.qb")
+
+	// These two paths have the same checksum
+	_, err := ParsePreSpecText("a.qb -> qb\\item29685295.qb\nb.qb -> qb\\item32060020.qb\n", filepath.Join(directory, "spec.ps"))
+	expectPreSpecError(t, err, 2, "has the same checksum")
+}
+
+func TestParsePreSpecPacksSourceCodeAsQb(t *testing.T) {
+	directory := t.TempDir()
+	writeFiles(t, directory, "src/a.ns", "src/sub/b.NS", "src/c.qb")
+
+	preSpec, err := ParsePreSpecText("# Source code\nsrc -> qb\\\nsrc/a.ns -> qb\\other\\\nsrc/a.ns -> qb\\kept.ns\n", filepath.Join(directory, "spec.ps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPathsInsidePre(t, preSpec, "qb\\a.qb", "qb\\c.qb", "qb\\sub\\b.qb", "qb\\other\\a.qb", "qb\\kept.ns")
+}
@@ -0,0 +1,226 @@
+package pre_generator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/byxor/NeverScript/compiler"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// Writes a pre one item at a time, so only the item being written needs to be held in memory.
+// The global header is written last (by Close), once the size and number of items are known.
+type PreWriter struct {
+	output        io.WriteSeeker
+	start         int64  // Where the pre starts in the output
+	offset        uint64 // How many bytes of the pre have been written
+	numberOfItems uint32
+	closed        bool
+}
+
+func NewPreWriter(output io.WriteSeeker) (*PreWriter, error) {
+	start, err := output.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	writer := &PreWriter{
+		output: output,
+		start:  start,
+	}
+
+	// Placeholder for the global header
+	if err := writer.write(make([]byte, preHeaderSize)); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Adds an item that's already in memory, compressing it if asked to (unless that would make it bigger).
+func (writer *PreWriter) WriteItem(pathInsidePre string, contents []byte, compress bool) error {
+	if uint64(len(contents)) > math.MaxUint32 {
+		return errors.New(fmt.Sprintf("'%s' is too big to fit in a pre (%d bytes)", pathInsidePre, len(contents)))
+	}
+
+	inflatedSize := uint32(len(contents))
+	deflatedSize := uint32(0)
+	if compress {
+		compressedContents := CompressLzss(contents)
+		if len(compressedContents) < len(contents) {
+			contents = compressedContents
+			deflatedSize = uint32(len(contents))
+		}
+	}
+
+	if err := writer.writeItemHeader(pathInsidePre, inflatedSize, deflatedSize); err != nil {
+		return err
+	}
+	if err := writer.write(contents); err != nil {
+		return err
+	}
+	return writer.align()
+}
+
+// Adds a file from disk. Files that aren't compressed are copied across in chunks rather than being read in full.
+func (writer *PreWriter) WriteFile(pathOnDisk string, pathInsidePre string, compress bool) error {
+	if compress {
+		contents, err := ioutil.ReadFile(pathOnDisk)
+		if err != nil {
+			return err
+		}
+		return writer.WriteItem(pathInsidePre, contents, true)
+	}
+
+	file, err := os.Open(pathOnDisk)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if fileInfo.Size() > math.MaxUint32 {
+		return errors.New(fmt.Sprintf("'%s' is too big to fit in a pre (%d bytes)", pathOnDisk, fileInfo.Size()))
+	}
+	size := uint32(fileInfo.Size())
+
+	if err := writer.writeItemHeader(pathInsidePre, size, 0); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, 64*1024)
+	for remaining := size; remaining > 0; {
+		chunkSize := uint32(len(chunk))
+		if remaining < chunkSize {
+			chunkSize = remaining
+		}
+		if _, err := io.ReadFull(file, chunk[:chunkSize]); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't read '%s': %s", pathOnDisk, err))
+		}
+		if err := writer.write(chunk[:chunkSize]); err != nil {
+			return err
+		}
+		remaining -= chunkSize
+	}
+	return writer.align()
+}
+
+// Adds an item from another pre exactly as it was stored there.
+func (writer *PreWriter) WriteRawItem(item PreItem) error {
+	if writer.closed {
+		return errors.New("Can't add items to a pre writer that was closed")
+	}
+	if writer.numberOfItems == math.MaxUint32 {
+		return errors.New("Pre has too many items")
+	}
+	writer.numberOfItems++
+
+	if err := writer.write(item.Header); err != nil {
+		return err
+	}
+	if err := writer.write(item.Data); err != nil {
+		return err
+	}
+	return writer.align()
+}
+
+// Writes the global header. The output is left positioned at the end of the pre.
+func (writer *PreWriter) Close() error {
+	if writer.closed {
+		return errors.New("Pre writer was already closed")
+	}
+	writer.closed = true
+
+	header := make([]byte, preHeaderSize)
+	binary.LittleEndian.PutUint32(header, uint32(writer.offset))
+	binary.LittleEndian.PutUint32(header[4:], PreVersion)
+	binary.LittleEndian.PutUint32(header[8:], writer.numberOfItems)
+
+	if _, err := writer.output.Seek(writer.start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := writer.output.Write(header); err != nil {
+		return err
+	}
+	_, err := writer.output.Seek(writer.start+int64(writer.offset), io.SeekStart)
+	return err
+}
+
+func (writer *PreWriter) writeItemHeader(pathInsidePre string, inflatedSize uint32, deflatedSize uint32) error {
+	if writer.closed {
+		return errors.New("Can't add items to a pre writer that was closed")
+	}
+
+	// The path is null-terminated, then padded so the contents start on a 4-byte boundary
+	pathInsidePreLength := len(pathInsidePre) + 1
+	for pathInsidePreLength%4 != 0 {
+		pathInsidePreLength++
+	}
+
+	header := make([]byte, preItemHeaderSize+pathInsidePreLength)
+	binary.LittleEndian.PutUint32(header, inflatedSize)
+	binary.LittleEndian.PutUint32(header[4:], deflatedSize)
+	binary.LittleEndian.PutUint32(header[8:], uint32(pathInsidePreLength))
+	binary.LittleEndian.PutUint32(header[12:], compiler.StringToChecksum(pathInsidePre))
+	copy(header[preItemHeaderSize:], pathInsidePre)
+
+	if writer.numberOfItems == math.MaxUint32 {
+		return errors.New("Pre has too many items")
+	}
+	writer.numberOfItems++
+	return writer.write(header)
+}
+
+func (writer *PreWriter) align() error {
+	var padding []byte
+	for (writer.offset+uint64(len(padding)))%4 != 0 {
+		padding = append(padding, 0)
+	}
+	return writer.write(padding)
+}
+
+func (writer *PreWriter) write(data []byte) error {
+	if writer.offset+uint64(len(data)) > math.MaxUint32 {
+		return errors.New("Pre would be bigger than 4 GB, which its header can't describe")
+	}
+	if _, err := writer.output.Write(data); err != nil {
+		return err
+	}
+	writer.offset += uint64(len(data))
+	return nil
+}
+
+// An in-memory io.WriteSeeker, for building small pres without touching the disk.
+type memoryWriteSeeker struct {
+	Bytes    []byte
+	position int64
+}
+
+func (buffer *memoryWriteSeeker) Write(data []byte) (int, error) {
+	end := buffer.position + int64(len(data))
+	if end > int64(len(buffer.Bytes)) {
+		buffer.Bytes = append(buffer.Bytes, make([]byte, end-int64(len(buffer.Bytes)))...)
+	}
+	copy(buffer.Bytes[buffer.position:], data)
+	buffer.position = end
+	return len(data), nil
+}
+
+func (buffer *memoryWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	position := offset
+	switch whence {
+	case io.SeekCurrent:
+		position += buffer.position
+	case io.SeekEnd:
+		position += int64(len(buffer.Bytes))
+	}
+	if position < 0 {
+		return buffer.position, errors.New(fmt.Sprintf("Can't seek to %d", position))
+	}
+	buffer.position = position
+	return position, nil
+}
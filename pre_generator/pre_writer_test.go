@@ -0,0 +1,138 @@
+package pre_generator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMakePreFileMatchesInMemoryPre(t *testing.T) {
+	directory := t.TempDir()
+
+	var preSpec PreSpec
+	for name, data := range syntheticData() {
+		pathOnDisk := filepath.Join(directory, name)
+		if err := ioutil.WriteFile(pathOnDisk, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		preSpec = append(preSpec, PreSpecItem{PathOnDisk: pathOnDisk, PathInsidePre: "data\\" + name})
+	}
+
+	// Big enough to be copied in more than one chunk
+	bigFile := filepath.Join(directory, "big")
+	if err := ioutil.WriteFile(bigFile, bytes.Repeat([]byte("0123456789abc"), 50000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	preSpec = append(preSpec, PreSpecItem{PathOnDisk: bigFile, PathInsidePre: "big"})
+
+	expected, err := MakePre(preSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prePath := filepath.Join(directory, "output.prx")
+	if err := MakePreFile(prePath, preSpec, PreSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ioutil.ReadFile(prePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("pre written to disk (%d bytes) doesn't match pre made in memory (%d bytes)", len(actual), len(expected))
+	}
+}
+
+func TestMakePreReportsMissingFiles(t *testing.T) {
+	preSpec := PreSpec{{PathOnDisk: filepath.Join(t.TempDir(), "missing.qb"), PathInsidePre: "missing.qb"}}
+	if _, err := MakePre(preSpec); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestPreWriterCanStartPartWayThroughOutput(t *testing.T) {
+	var buffer memoryWriteSeeker
+	buffer.Write([]byte("prefix"))
+
+	writer, err := NewPreWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteItem("a.txt", []byte("hello"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err == nil {
+		t.Error("expected an error when closing twice")
+	}
+
+	pre, err := ReadPre(buffer.Bytes[len("prefix"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pre.Items) != 1 || pre.Items[0].PathInsidePre != "a.txt" || string(pre.Items[0].Data) != "hello" {
+		t.Errorf("unexpected items: %+v", pre.Items)
+	}
+}
+
+func TestMakePreCompilesSourceCode(t *testing.T) {
+	directory := t.TempDir()
+	writeFiles(t, directory, "a.ns", "b.txt")
+	preSpec := PreSpec{
+		{PathOnDisk: filepath.Join(directory, "a.ns"), PathInsidePre: "qb\\a.qb"},
+		{PathOnDisk: filepath.Join(directory, "b.txt"), PathInsidePre: "b.txt"},
+	}
+
+	if _, err := MakePre(preSpec); err == nil {
+		t.Error("expected an error when there's no way to compile source code")
+	}
+
+	var compiledFiles []string
+	settings := PreSettings{
+		Compile: func(nsFilePath string) ([]byte, error) {
+			compiledFiles = append(compiledFiles, nsFilePath)
+			return []byte("compiled"), nil
+		},
+	}
+	preBytes, err := MakePreWithSettings(preSpec, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pre, err := ReadPre(preBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(compiledFiles) != 1 || compiledFiles[0] != preSpec[0].PathOnDisk {
+		t.Errorf("expected only '%s' to be compiled, got %v", preSpec[0].PathOnDisk, compiledFiles)
+	}
+	if string(pre.Items[0].Data) != "compiled" || string(pre.Items[1].Data) != "b.txt" {
+		t.Errorf("unexpected contents: %q, %q", pre.Items[0].Data, pre.Items[1].Data)
+	}
+}
+
+func TestMakePreFileLeavesExistingFileAloneOnError(t *testing.T) {
+	directory := t.TempDir()
+	prePath := filepath.Join(directory, "existing.prx")
+	if err := ioutil.WriteFile(prePath, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	preSpec := PreSpec{{PathOnDisk: filepath.Join(directory, "missing.qb"), PathInsidePre: "missing.qb"}}
+	if err := MakePreFile(prePath, preSpec, PreSettings{}); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+
+	contents, _ := ioutil.ReadFile(prePath)
+	if string(contents) != "existing" {
+		t.Errorf("existing file was changed to %q", contents)
+	}
+	if _, err := os.Stat(prePath + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file was left behind")
+	}
+}